@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Andrew-Morozko/orca/config"
+	"github.com/Andrew-Morozko/orca/jobcontroller"
+	"github.com/Andrew-Morozko/orca/orca"
+)
+
+// Admin API, JSON over HTTP:
+//   GET  /api/images                     images with their containers and users
+//   POST /api/images/<kind>/<name>/drain stop taking new users, name could have "/"
+//   POST /api/containers/<id>/stop       id could be a unique prefix
+//   POST /api/users/<id>/kick            ends the sessions in all images
+//   POST /api/rescan                     same as SIGUSR1
+
+type adminUser struct {
+	User          string `json:"user"`
+	Container     string `json:"container,omitempty"`
+	State         string `json:"state"`
+	Error         string `json:"error,omitempty"`
+	ExitCode      int64  `json:"exit_code,omitempty"`
+	Connections   int    `json:"connections"`
+	QueuePosition int    `json:"queue_position,omitempty"`
+	SessionLeft   string `json:"session_left"`
+	InactiveLeft  string `json:"inactive_left"`
+}
+
+type adminContainer struct {
+	ID              string      `json:"id"`
+	StartedAt       time.Time   `json:"started_at"`
+	ConcurrentUsers int         `json:"concurrent_users"`
+	TotalUsers      int         `json:"total_users"`
+	ReservedUsers   int         `json:"reserved_users"`
+	Warm            bool        `json:"warm"`
+	EndOfLife       bool        `json:"end_of_life"`
+	Users           []adminUser `json:"users"`
+}
+
+type adminImage struct {
+	Kind       string           `json:"kind"`
+	Name       string           `json:"name"`
+	ID         string           `json:"id"`
+	Removed    bool             `json:"removed"`
+	Containers []adminContainer `json:"containers"`
+	// users without a container: starting, queued or failed to start
+	PendingUsers []adminUser `json:"pending_users"`
+}
+
+func newAdminUser(info orca.ContainerUserInfo) adminUser {
+	au := adminUser{
+		User:          info.User,
+		Container:     info.ContainerID,
+		State:         info.Status.ContainerState.String(),
+		ExitCode:      info.Status.Status,
+		Connections:   info.Connections,
+		QueuePosition: info.QueuePosition,
+		SessionLeft:   info.SessionLeft.Round(time.Second).String(),
+		InactiveLeft:  info.InactiveLeft.Round(time.Second).String(),
+	}
+	if info.Status.Err != nil {
+		au.Error = info.Status.Err.Error()
+	}
+	return au
+}
+
+func newAdminImage(info orca.ImageInfo) adminImage {
+	ai := adminImage{
+		Kind:         info.Kind,
+		Name:         info.Name,
+		ID:           info.DockerID,
+		Removed:      info.Removed,
+		Containers:   []adminContainer{},
+		PendingUsers: []adminUser{},
+	}
+	usersByContainer := make(map[string][]adminUser)
+	for _, cuInfo := range info.Users {
+		au := newAdminUser(cuInfo)
+		usersByContainer[au.Container] = append(usersByContainer[au.Container], au)
+	}
+	for _, ocInfo := range info.Containers {
+		users := usersByContainer[ocInfo.DockerID]
+		delete(usersByContainer, ocInfo.DockerID)
+		if users == nil {
+			users = []adminUser{}
+		}
+		ai.Containers = append(ai.Containers, adminContainer{
+			ID:              ocInfo.DockerID,
+			StartedAt:       ocInfo.StartedAt,
+			ConcurrentUsers: ocInfo.ConcurrentUsers,
+			TotalUsers:      ocInfo.TotalUsers,
+			ReservedUsers:   ocInfo.ReservedUsers,
+			Warm:            ocInfo.Warm,
+			EndOfLife:       ocInfo.EndOfLife,
+			Users:           users,
+		})
+	}
+	// including the users of the containers that are gone by now
+	for _, users := range usersByContainer {
+		ai.PendingUsers = append(ai.PendingUsers, users...)
+	}
+	return ai
+}
+
+func writeJSON(resp http.ResponseWriter, status int, val interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	_ = json.NewEncoder(resp).Encode(val)
+}
+
+func writeJSONError(resp http.ResponseWriter, status int, msg string) {
+	writeJSON(resp, status, map[string]string{"error": msg})
+}
+
+// Token is checked on every request, so it could be changed by the config reload
+func adminAuthorized(req *http.Request) bool {
+	token := config.Get().Admin.Token
+	auth := req.Header.Get("Authorization")
+	if token == "" || !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+var adminSnapshotTimeout = 5 * time.Second
+
+func adminAPI(jc jobcontroller.JobController) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !adminAuthorized(req) {
+			jc.Logger.Warn.Logf("Unauthorized request from %s", req.RemoteAddr)
+			writeJSONError(resp, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		rest := strings.Trim(strings.TrimPrefix(req.URL.Path, "/api"), "/")
+		path := strings.Split(rest, "/")
+
+		if req.Method == http.MethodGet {
+			if len(path) != 1 || path[0] != "images" {
+				writeJSONError(resp, http.StatusNotFound, "not found")
+				return
+			}
+			ctx, cancel := context.WithTimeout(req.Context(), adminSnapshotTimeout)
+			defer cancel()
+			infos, err := imageList.Snapshot(ctx)
+			if err != nil {
+				jc.Logger.Err(err, "failed to take a snapshot")
+				writeJSONError(resp, http.StatusInternalServerError, err.Error())
+				return
+			}
+			images := make([]adminImage, len(infos))
+			for n, info := range infos {
+				images[n] = newAdminImage(info)
+			}
+			writeJSON(resp, http.StatusOK, images)
+			return
+		}
+		if req.Method != http.MethodPost {
+			writeJSONError(resp, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var err error
+		switch {
+		case len(path) >= 4 && path[0] == "images" && path[len(path)-1] == "drain":
+			// image names could have "/" in them: "myorg/task"
+			parts := strings.SplitN(strings.TrimSuffix(rest, "/drain"), "/", 3)
+			jc.Logger.Logf("Draining image %s/%s", parts[1], parts[2])
+			err = imageList.DrainImage(parts[1], parts[2])
+		case len(path) == 3 && path[0] == "containers" && path[2] == "stop":
+			jc.Logger.Logf("Stopping container %s", path[1])
+			err = imageList.StopContainer(path[1])
+		case len(path) == 3 && path[0] == "users" && path[2] == "kick":
+			kicked := imageList.KickUser(path[1])
+			jc.Logger.Logf("Kicked user %s from %d images", path[1], kicked)
+			writeJSON(resp, http.StatusOK, map[string]int{"kicked": kicked})
+			return
+		case len(path) == 1 && path[0] == "rescan":
+			jc.Logger.Log("Rescan requested")
+			imageList.Reload()
+		default:
+			writeJSONError(resp, http.StatusNotFound, "not found")
+			return
+		}
+
+		switch err {
+		case nil:
+			writeJSON(resp, http.StatusOK, map[string]string{})
+		case orca.ImageNotFoundErr, orca.ContainerNotFoundErr:
+			writeJSONError(resp, http.StatusNotFound, err.Error())
+		default:
+			writeJSONError(resp, http.StatusBadRequest, err.Error())
+		}
+	})
+}
+
+func adminHandler(jc jobcontroller.JobController, shutdownReq <-chan struct{}) {
+	addr := config.Get().Admin.Listen
+	if addr == "" {
+		return
+	}
+	jc = jc.AddLoggerPrefix("Admin API")
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", adminAPI(jc))
+	s := http.Server{Addr: addr, Handler: mux}
+
+	jc.Job.Add(1)
+	go func() {
+		defer jc.Job.Done()
+		jc.Logger.Log("Starting admin server on ", s.Addr)
+		go func() {
+			err := s.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				jc.Logger.Fatal.Err(err)
+			}
+		}()
+
+		select {
+		case <-shutdownReq:
+			err := s.Shutdown(jc.ShutdownCtx)
+			if err != nil {
+				s.Close()
+			}
+		case <-jc.Done():
+			s.Close()
+		}
+	}()
+}
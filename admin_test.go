@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Andrew-Morozko/orca/config"
+	"github.com/Andrew-Morozko/orca/jobcontroller"
+	"github.com/Andrew-Morozko/orca/mylog"
+	"github.com/Andrew-Morozko/orca/orca"
+)
+
+func TestAdminAPI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sc, err := jobcontroller.New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// messages are dropped, nobody reads them
+	logger, _ := mylog.NewBaseLoggerWithPolicy(ctx, mylog.Debug, 0, mylog.OverflowDropNewest)
+	jc := sc.GetJobController(logger)
+
+	defer config.Set(config.Get())
+	conf := config.Default()
+	conf.Admin.Token = "secret"
+	config.Set(conf)
+
+	prevList := imageList
+	defer func() { imageList = prevList }()
+	imageList = &orca.ImageList{}
+
+	srv := httptest.NewServer(adminAPI(jc))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		method, path, auth string
+		status             int
+		body               string
+	}{
+		{"GET", "/api/images", "", http.StatusUnauthorized, `{"error":"unauthorized"}`},
+		{"GET", "/api/images", "Bearer wrong", http.StatusUnauthorized, `{"error":"unauthorized"}`},
+		{"GET", "/api/images", "secret", http.StatusUnauthorized, `{"error":"unauthorized"}`},
+		{"GET", "/api/images", "Bearer secret", http.StatusOK, `[]`},
+		{"GET", "/api/users", "Bearer secret", http.StatusNotFound, `{"error":"not found"}`},
+		{"PUT", "/api/rescan", "Bearer secret", http.StatusMethodNotAllowed, `{"error":"method not allowed"}`},
+		{"POST", "/api/nothing", "Bearer secret", http.StatusNotFound, `{"error":"not found"}`},
+		{"POST", "/api/rescan", "Bearer secret", http.StatusOK, `{}`},
+		// the route is found, the image isn't
+		{"POST", "/api/images/ssh/myorg/task/drain", "Bearer secret", http.StatusNotFound, `{"error":"image not found"}`},
+		{"POST", "/api/containers/abc/stop", "Bearer secret", http.StatusNotFound, `{"error":"container not found"}`},
+		{"POST", "/api/users/alice/kick", "Bearer secret", http.StatusOK, `{"kicked":0}`},
+	} {
+		req, err := http.NewRequest(tc.method, srv.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s %s: %s", tc.method, tc.path, err)
+		}
+		if resp.StatusCode != tc.status || string(body) != tc.body {
+			t.Errorf("%s %s (%q): got %d %s, expected %d %s",
+				tc.method, tc.path, tc.auth, resp.StatusCode, body, tc.status, tc.body)
+		}
+	}
+}
+
+func TestNewAdminImage(t *testing.T) {
+	started := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ai := newAdminImage(orca.ImageInfo{
+		Kind:     orca.ImageKindSSH,
+		Name:     "myorg/task",
+		DockerID: "sha256:1234",
+		Containers: []orca.ContainerInfo{
+			{DockerID: "c1", StartedAt: started, ConcurrentUsers: 1, TotalUsers: 3},
+			{DockerID: "c2", StartedAt: started, Warm: true},
+		},
+		Users: []orca.ContainerUserInfo{
+			{
+				User:        "alice",
+				ContainerID: "c1",
+				Status:      orca.ContainerStatus{ContainerState: orca.ContainerStateWorking},
+				Connections: 1,
+				SessionLeft: 90 * time.Second,
+			},
+			{
+				User:          "bob",
+				Status:        orca.ContainerStatus{ContainerState: orca.ContainerStateStarting, Err: orca.QueuedErr},
+				QueuePosition: 2,
+			},
+		},
+	})
+	out, err := json.Marshal(ai)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		`{"kind":"ssh","name":"myorg/task","id":"sha256:1234","removed":false,"containers":[`,
+		`{"id":"c1","started_at":"2020-01-02T03:04:05Z","concurrent_users":1,"total_users":3,"reserved_users":0,"warm":false,"end_of_life":false,"users":[`,
+		`{"user":"alice","container":"c1","state":"ContainerStateWorking","connections":1,"session_left":"1m30s","inactive_left":"0s"}]},`,
+		`{"id":"c2","started_at":"2020-01-02T03:04:05Z","concurrent_users":0,"total_users":0,"reserved_users":0,"warm":true,"end_of_life":false,"users":[]}],`,
+		`"pending_users":[{"user":"bob","state":"ContainerStateStarting","error":"waiting in the queue for the container","connections":0,"queue_position":2,"session_left":"0s","inactive_left":"0s"}]}`,
+	}, "")
+	if string(out) != expected {
+		t.Errorf("got\n%s\nexpected\n%s", out, expected)
+	}
+}
@@ -0,0 +1,327 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Andrew-Morozko/orca/mylog"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	HTTP struct {
+		Listen string `yaml:"listen"`
+		// %s is replaced with the url the user came from
+		LoginURL       string `yaml:"login_url"`
+		IdentityCookie string `yaml:"identity_cookie"`
+		// %s is replaced with the image name, passed to the web containers
+		ContainerURLFormat string `yaml:"container_url_format"`
+		TokenChecker       string `yaml:"token_checker"`
+	} `yaml:"http"`
+
+	SSH struct {
+		Listen string `yaml:"listen"`
+		// glob of the host keys
+		HostKeys   string `yaml:"host_keys"`
+		LDAPServer string `yaml:"ldap_server"`
+		// task menu of the pty sessions: "line" prompt or full screen "tui"
+		Menu string `yaml:"menu"`
+	} `yaml:"ssh"`
+
+	// disabled if listen is empty
+	Admin struct {
+		Listen string `yaml:"listen"`
+		// required in the "Authorization: Bearer <token>" header
+		Token string `yaml:"token"`
+	} `yaml:"admin"`
+
+	Metrics struct {
+		// prometheus /metrics, disabled if empty
+		Listen string `yaml:"listen"`
+	} `yaml:"metrics"`
+
+	Log struct {
+		Sinks []LogSink `yaml:"sinks"`
+		// messages waiting for the sinks
+		Buffer int `yaml:"buffer"`
+		// what to do when the buffer is full: "block", "drop_oldest" or "drop_newest"
+		Overflow string `yaml:"overflow"`
+	} `yaml:"log"`
+
+	Docker struct {
+		// API version, negotiated by default
+		Version string `yaml:"version"`
+	} `yaml:"docker"`
+
+	Containers struct {
+		// -1 if unlimited
+		Max int `yaml:"max"`
+		// attempts to start the container (or the ssh server)
+		MaxRestarts int `yaml:"max_restarts"`
+		// idle containers are deleted after that
+		DeletionTime time.Duration `yaml:"deletion_time"`
+		// time to collect candidates with free spots
+		ElectionLength time.Duration `yaml:"election_length"`
+		// name of the container orca runs in, if any
+		SelfContainer string `yaml:"self_container"`
+	} `yaml:"containers"`
+
+	// sessions of the images with orca.record=true
+	Recordings struct {
+		Dir string `yaml:"dir"`
+		// older recordings are removed, kept forever if 0
+		MaxAge time.Duration `yaml:"max_age"`
+		// oldest recordings are removed to fit, unlimited if 0
+		MaxSizeMB int `yaml:"max_size_mb"`
+	} `yaml:"recordings"`
+
+	Security struct {
+		Profile    string `yaml:"profile"`
+		Runtime    string `yaml:"runtime"`
+		SeccompDir string `yaml:"seccomp_dir"`
+	} `yaml:"security"`
+}
+
+// Destination of the logs
+type LogSink struct {
+	// "stderr", "file" or "syslog"
+	Type string `yaml:"type"`
+	// "text" or "json"
+	Format string `yaml:"format"`
+	// minimal level, "debug" by default
+	Level string `yaml:"level"`
+
+	// file
+	Path string `yaml:"path"`
+	// rotated after that many megabytes, never if 0
+	MaxSizeMB  int `yaml:"max_size_mb"`
+	MaxBackups int `yaml:"max_backups"`
+
+	// syslog, local if empty, "udp://host:514" otherwise
+	Address string `yaml:"address"`
+	Tag     string `yaml:"tag"`
+}
+
+func Default() *Config {
+	c := &Config{}
+	c.Log.Sinks = []LogSink{{Type: "stderr", Format: "text", Level: "debug"}}
+	c.Log.Buffer = 200
+	// log is the only record of logins, kicks and container failures,
+	// losing it has to be a choice. The buffer absorbs the bursts.
+	c.Log.Overflow = "block"
+	c.HTTP.Listen = ":8080"
+	c.HTTP.IdentityCookie = "ORCA_AUTH_TOKEN"
+	c.SSH.Listen = ":22222"
+	c.SSH.HostKeys = "./server_keys/id_*"
+	c.SSH.Menu = "line"
+	c.Containers.Max = -1
+	c.Containers.MaxRestarts = 5
+	c.Containers.DeletionTime = 30 * time.Second
+	c.Containers.ElectionLength = 5 * time.Millisecond
+	c.Recordings.Dir = "./recordings"
+	c.Recordings.MaxAge = 30 * 24 * time.Hour
+	c.Recordings.MaxSizeMB = 1024
+	c.Security.Profile = "default"
+	c.Security.SeccompDir = "./seccomp"
+	return c
+}
+
+func setInt(dest *int) func(string) error {
+	return func(val string) (err error) {
+		*dest, err = strconv.Atoi(val)
+		return
+	}
+}
+
+func setDuration(dest *time.Duration) func(string) error {
+	return func(val string) (err error) {
+		*dest, err = time.ParseDuration(val)
+		return
+	}
+}
+
+func setString(dest *string) func(string) error {
+	return func(val string) error {
+		*dest = val
+		return nil
+	}
+}
+
+// Environment variables take precedence over the file
+func (c *Config) envOverrides() map[string]func(string) error {
+	return map[string]func(string) error{
+		"ORCA_HTTP_LISTEN":               setString(&c.HTTP.Listen),
+		"ORCA_HTTP_LOGIN_URL":            setString(&c.HTTP.LoginURL),
+		"ORCA_HTTP_USER_IDENTITY_COOKIE": setString(&c.HTTP.IdentityCookie),
+		"ORCA_HTTP_CONTAINER_URL_FORMAT": setString(&c.HTTP.ContainerURLFormat),
+		"ORCA_HTTP_TOKEN_CHECKER":        setString(&c.HTTP.TokenChecker),
+		"ORCA_SSH_LISTEN":                setString(&c.SSH.Listen),
+		"ORCA_SSH_HOST_KEYS":             setString(&c.SSH.HostKeys),
+		"ORCA_GRPC_LDAP_SERVER":          setString(&c.SSH.LDAPServer),
+		"ORCA_SSH_MENU":                  setString(&c.SSH.Menu),
+		"ORCA_ADMIN_LISTEN":              setString(&c.Admin.Listen),
+		"ORCA_ADMIN_TOKEN":               setString(&c.Admin.Token),
+		"ORCA_METRICS_LISTEN":            setString(&c.Metrics.Listen),
+		"ORCA_DOCKER_VERSION":            setString(&c.Docker.Version),
+		"ORCA_MAX_CONTAINERS":            setInt(&c.Containers.Max),
+		"ORCA_MAX_RESTARTS":              setInt(&c.Containers.MaxRestarts),
+		"ORCA_CONTAINER_DELETION_TIME":   setDuration(&c.Containers.DeletionTime),
+		"ORCA_ELECTION_LENGTH":           setDuration(&c.Containers.ElectionLength),
+		"ORCA_SELF_CONTAINER":            setString(&c.Containers.SelfContainer),
+		"ORCA_RECORDINGS_DIR":            setString(&c.Recordings.Dir),
+		"ORCA_RECORDINGS_MAX_AGE":        setDuration(&c.Recordings.MaxAge),
+		"ORCA_RECORDINGS_MAX_SIZE_MB":    setInt(&c.Recordings.MaxSizeMB),
+		"ORCA_SECURITY_PROFILE":          setString(&c.Security.Profile),
+		"ORCA_CONTAINER_RUNTIME":         setString(&c.Security.Runtime),
+		"ORCA_SECCOMP_DIR":               setString(&c.Security.SeccompDir),
+	}
+}
+
+// Defaults, overridden by the file (if path isn't empty), overridden by the env.
+// Not validated: not everything is needed by every command.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithMessage(err, "reading config")
+		}
+		// strict: typos in the keys are errors
+		err = yaml.UnmarshalStrict(data, c)
+		if err != nil {
+			return nil, errors.WithMessage(err, "parsing "+path)
+		}
+	}
+	for name, set := range c.envOverrides() {
+		val, found := os.LookupEnv(name)
+		if !found {
+			continue
+		}
+		err := set(val)
+		if err != nil {
+			return nil, errors.WithMessage(err, "parsing "+name)
+		}
+	}
+	return c, nil
+}
+
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, msg string) {
+		if !ok {
+			errs = append(errs, msg)
+		}
+	}
+	check(c.HTTP.Listen != "", "http.listen is empty")
+	check(strings.Count(c.HTTP.LoginURL, "%s") == 1, "http.login_url must contain exactly one %s")
+	check(c.HTTP.IdentityCookie != "", "http.identity_cookie is empty")
+	check(c.HTTP.TokenChecker != "", "http.token_checker is empty")
+	check(c.SSH.Listen != "", "ssh.listen is empty")
+	check(c.SSH.HostKeys != "", "ssh.host_keys is empty")
+	check(c.SSH.LDAPServer != "", "ssh.ldap_server is empty")
+	check(c.SSH.Menu == "line" || c.SSH.Menu == "tui", `ssh.menu must be "line" or "tui"`)
+	check(c.Admin.Listen == "" || c.Admin.Token != "", "admin.token is required if admin.listen is set")
+	check(c.Containers.Max >= -1, "containers.max must be -1 (unlimited) or more")
+	check(c.Containers.MaxRestarts >= 1, "containers.max_restarts must be positive")
+	check(c.Containers.DeletionTime > 0, "containers.deletion_time must be positive")
+	check(c.Containers.ElectionLength > 0, "containers.election_length must be positive")
+	check(len(c.Log.Sinks) != 0, "log.sinks is empty")
+	for n, sink := range c.Log.Sinks {
+		name := fmt.Sprintf("log.sinks[%d]", n)
+		check(sink.Type == "stderr" || sink.Type == "file" || sink.Type == "syslog",
+			name+`.type must be "stderr", "file" or "syslog"`)
+		check(sink.Type != "file" || sink.Path != "", name+".path is required for the file")
+		if sink.Format != "" {
+			_, err := mylog.ParseFormat(sink.Format)
+			check(err == nil, fmt.Sprintf("%s.format: %s", name, err))
+		}
+		if sink.Level != "" {
+			_, err := mylog.ParseLevel(sink.Level)
+			check(err == nil, fmt.Sprintf("%s.level: %s", name, err))
+		}
+		check(sink.MaxSizeMB >= 0 && sink.MaxBackups >= 0, name+": max_size_mb and max_backups can't be negative")
+	}
+	check(c.Recordings.Dir != "", "recordings.dir is empty")
+	check(c.Recordings.MaxAge >= 0 && c.Recordings.MaxSizeMB >= 0,
+		"recordings.max_age and recordings.max_size_mb can't be negative")
+	check(c.Log.Buffer >= 0, "log.buffer can't be negative")
+	_, err := mylog.ParseOverflowPolicy(c.Log.Overflow)
+	check(err == nil, fmt.Sprintf("log.overflow: %s", err))
+	// same as orca.SecurityProfile*
+	check(c.Security.Profile == "default" || c.Security.Profile == "hardened",
+		`security.profile must be "default" or "hardened"`)
+	if len(errs) != 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+var current atomic.Value
+
+func init() {
+	current.Store(Default())
+}
+
+// Current config, must not be modified
+func Get() *Config {
+	return current.Load().(*Config)
+}
+
+func Set(c *Config) {
+	current.Store(c)
+}
+
+// Loads the config again, but applies only the settings that are safe to change
+// at runtime. Returns the names of the changed settings that require a restart.
+func Reload(path string) (ignored []string, err error) {
+	newC, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	err = newC.Validate()
+	if err != nil {
+		return nil, err
+	}
+	c := *Get()
+
+	c.HTTP.LoginURL = newC.HTTP.LoginURL
+	c.HTTP.IdentityCookie = newC.HTTP.IdentityCookie
+	c.HTTP.ContainerURLFormat = newC.HTTP.ContainerURLFormat
+	c.HTTP.TokenChecker = newC.HTTP.TokenChecker
+	c.Admin.Token = newC.Admin.Token
+	c.Containers.Max = newC.Containers.Max
+	c.Containers.MaxRestarts = newC.Containers.MaxRestarts
+	c.Containers.DeletionTime = newC.Containers.DeletionTime
+	c.Containers.ElectionLength = newC.Containers.ElectionLength
+	c.Recordings = newC.Recordings
+
+	// everything else has to stay the same
+	unsafe := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"http.listen", c.HTTP.Listen, newC.HTTP.Listen},
+		{"ssh", c.SSH, newC.SSH},
+		{"admin.listen", c.Admin.Listen, newC.Admin.Listen},
+		{"metrics", c.Metrics, newC.Metrics},
+		{"log", c.Log, newC.Log},
+		{"docker", c.Docker, newC.Docker},
+		{"containers.self_container", c.Containers.SelfContainer, newC.Containers.SelfContainer},
+		{"security", c.Security, newC.Security},
+	}
+	for _, setting := range unsafe {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			ignored = append(ignored, setting.name)
+		}
+	}
+
+	Set(&c)
+	return ignored, nil
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "orca.yml")
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeConfig(t, dir, `
+http:
+  login_url: "https://example.com/login?next=%s"
+  token_checker: "https://example.com/check"
+ssh:
+  ldap_server: "127.0.0.1:8888"
+containers:
+  max: 10
+  deletion_time: 1m
+`)
+	os.Setenv("ORCA_MAX_CONTAINERS", "20")
+	defer os.Unsetenv("ORCA_MAX_CONTAINERS")
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Containers.Max != 20 {
+		t.Errorf("env should override the file, max = %d", c.Containers.Max)
+	}
+	if c.Containers.DeletionTime != time.Minute {
+		t.Errorf("deletion_time = %s", c.Containers.DeletionTime)
+	}
+	if c.SSH.Listen != ":22222" {
+		t.Errorf("default is lost, ssh.listen = %s", c.SSH.Listen)
+	}
+
+	path = writeConfig(t, dir, "containers:\n  maximum: 10\n")
+	if _, err := Load(path); err == nil {
+		t.Error("unknown key is accepted")
+	}
+
+	if err := Default().Validate(); err == nil {
+		t.Error("default config without login url and servers is valid")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer Set(Get())
+
+	base := `
+http:
+  login_url: "https://example.com/login?next=%s"
+  token_checker: "https://example.com/check"
+ssh:
+  ldap_server: "127.0.0.1:8888"
+`
+	c, err := Load(writeConfig(t, dir, base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Set(c)
+
+	path := writeConfig(t, dir, base+`
+  listen: ":2222"
+containers:
+  max: 3
+`)
+	ignored, err := Reload(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ignored) != 1 || ignored[0] != "ssh" {
+		t.Errorf("ignored = %v", ignored)
+	}
+	if Get().Containers.Max != 3 {
+		t.Errorf("max = %d", Get().Containers.Max)
+	}
+	if Get().SSH.Listen != ":22222" {
+		t.Errorf("ssh.listen changed at runtime to %s", Get().SSH.Listen)
+	}
+
+	// invalid config keeps the old one
+	path = writeConfig(t, dir, "containers:\n  max: -5\n")
+	if _, err := Reload(path); err == nil {
+		t.Error("invalid config is reloaded")
+	}
+	if Get().Containers.Max != 3 {
+		t.Errorf("max = %d", Get().Containers.Max)
+	}
+}
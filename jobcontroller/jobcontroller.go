@@ -283,3 +283,9 @@ func (jc JobController) AddLoggerPrefix(prefix string) JobController {
 	jc.Logger = jc.Logger.NewWithPrefix(prefix)
 	return jc
 }
+
+// Structured context, e.g. mylog.FieldUser
+func (jc JobController) AddLoggerField(key string, value interface{}) JobController {
+	jc.Logger = jc.Logger.WithField(key, value)
+	return jc
+}
@@ -147,10 +147,13 @@ func (m *KeyAuthRequest) GetPublicKey() []byte {
 }
 
 type AuthReply struct {
-	Status               AuthReply_AuthStatus `protobuf:"varint,1,opt,name=status,proto3,enum=AuthReply_AuthStatus" json:"status,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
-	XXX_unrecognized     []byte               `json:"-"`
-	XXX_sizecache        int32                `json:"-"`
+	Status AuthReply_AuthStatus `protobuf:"varint,1,opt,name=status,proto3,enum=AuthReply_AuthStatus" json:"status,omitempty"`
+	// string message = 2;
+	// groups of the authorized user
+	Groups               []string `protobuf:"bytes,3,rep,name=groups,proto3" json:"groups,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *AuthReply) Reset()         { *m = AuthReply{} }
@@ -185,6 +188,13 @@ func (m *AuthReply) GetStatus() AuthReply_AuthStatus {
 	return AuthReply_SERVER_ERROR
 }
 
+func (m *AuthReply) GetGroups() []string {
+	if m != nil {
+		return m.Groups
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("AuthReply_AuthStatus", AuthReply_AuthStatus_name, AuthReply_AuthStatus_value)
 	proto.RegisterType((*PasswdAuthRequest)(nil), "PasswdAuthRequest")
@@ -195,23 +205,24 @@ func init() {
 func init() { proto.RegisterFile("ldaplogin/ldaplogin.proto", fileDescriptor_60a91fa331746fa7) }
 
 var fileDescriptor_60a91fa331746fa7 = []byte{
-	// 247 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x90, 0x41, 0x4b, 0xc3, 0x40,
-	0x10, 0x85, 0x9b, 0x80, 0xd1, 0x3c, 0x4a, 0x8d, 0x83, 0x42, 0x2d, 0x1e, 0x24, 0xa7, 0x5e, 0x5c,
-	0x21, 0xfe, 0x82, 0x40, 0x56, 0x90, 0x04, 0x5a, 0xa6, 0xe0, 0x55, 0x52, 0xb3, 0x68, 0x21, 0x34,
-	0x6b, 0x77, 0x83, 0xe4, 0xdf, 0x4b, 0x37, 0x92, 0x50, 0x3c, 0x78, 0x9b, 0x37, 0xef, 0xf1, 0x31,
-	0xf3, 0x70, 0x5b, 0x57, 0xa5, 0xae, 0x9b, 0x8f, 0xdd, 0xfe, 0x71, 0x98, 0x84, 0x3e, 0x34, 0xb6,
-	0x89, 0x25, 0xae, 0xd6, 0xa5, 0x31, 0xdf, 0x55, 0xda, 0xda, 0x4f, 0x56, 0x5f, 0xad, 0x32, 0x96,
-	0xae, 0x71, 0xe6, 0x32, 0x73, 0xef, 0xde, 0x5b, 0x86, 0xdc, 0x0b, 0x5a, 0xe0, 0x42, 0x1f, 0xa3,
-	0xcd, 0xa1, 0x9a, 0xfb, 0xce, 0x18, 0x74, 0x9c, 0x61, 0x96, 0xab, 0xee, 0x7f, 0xc6, 0x1d, 0x42,
-	0xdd, 0x6e, 0xeb, 0xdd, 0x7b, 0xae, 0x3a, 0x07, 0x99, 0xf2, 0xb8, 0x88, 0xf7, 0x08, 0x7b, 0x84,
-	0xae, 0x3b, 0x7a, 0x40, 0x60, 0x6c, 0x69, 0x5b, 0xe3, 0x08, 0xb3, 0xe4, 0x46, 0x0c, 0x9e, 0x9b,
-	0x36, 0xce, 0xe4, 0xdf, 0x50, 0x9c, 0x00, 0xe3, 0x96, 0x22, 0x4c, 0x37, 0x92, 0x5f, 0x25, 0xbf,
-	0x49, 0xe6, 0x15, 0x47, 0x13, 0x0a, 0xe0, 0xaf, 0xf2, 0xc8, 0x23, 0x20, 0x78, 0x4e, 0x5f, 0x0a,
-	0x99, 0x45, 0x7e, 0xa2, 0x10, 0x16, 0x59, 0xba, 0x2e, 0xdc, 0x69, 0xa2, 0x07, 0xf4, 0x6d, 0x10,
-	0x89, 0x3f, 0xb5, 0x2c, 0x30, 0x5e, 0x10, 0x4f, 0x68, 0x89, 0xf3, 0xa3, 0xcc, 0x55, 0x47, 0x97,
-	0xe2, 0xf4, 0xf9, 0xd3, 0xe4, 0x36, 0x70, 0x55, 0x3f, 0xfd, 0x04, 0x00, 0x00, 0xff, 0xff, 0x93,
-	0x1d, 0x22, 0xf2, 0x87, 0x01, 0x00, 0x00,
+	// 262 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x91, 0xc1, 0x4a, 0xc3, 0x40,
+	0x10, 0x86, 0x9b, 0x14, 0xa3, 0x19, 0x4a, 0x8d, 0x83, 0x4a, 0x2c, 0x1e, 0x4a, 0x4e, 0xb9, 0x18,
+	0x21, 0x3e, 0x41, 0x20, 0x2b, 0x48, 0x02, 0x2d, 0x53, 0xf0, 0x2a, 0xa9, 0x59, 0x6a, 0x21, 0xb8,
+	0x6b, 0x76, 0x17, 0xc9, 0x0b, 0xf8, 0xdc, 0xd2, 0x4d, 0x49, 0x28, 0x1e, 0xbc, 0xcd, 0xbf, 0xf3,
+	0xf1, 0x31, 0xfc, 0x0b, 0x77, 0x4d, 0x5d, 0xc9, 0x46, 0xec, 0xf6, 0x9f, 0x8f, 0xc3, 0x94, 0xc8,
+	0x56, 0x68, 0x11, 0x31, 0xb8, 0x5a, 0x57, 0x4a, 0x7d, 0xd7, 0x99, 0xd1, 0x1f, 0xc4, 0xbf, 0x0c,
+	0x57, 0x1a, 0xaf, 0xe1, 0xcc, 0x32, 0xa1, 0xb3, 0x74, 0x62, 0x9f, 0xfa, 0x80, 0x0b, 0xb8, 0x90,
+	0x07, 0x54, 0xb4, 0x75, 0xe8, 0xda, 0xc5, 0x90, 0xa3, 0x1c, 0xe6, 0x05, 0xef, 0xfe, 0x77, 0xdc,
+	0x83, 0x2f, 0xcd, 0xb6, 0xd9, 0xbf, 0x17, 0xbc, 0xb3, 0x92, 0x19, 0x8d, 0x0f, 0xd1, 0x8f, 0x03,
+	0x7e, 0xef, 0x90, 0x4d, 0x87, 0x0f, 0xe0, 0x29, 0x5d, 0x69, 0xa3, 0xac, 0x62, 0x9e, 0xde, 0x24,
+	0xc3, 0xce, 0x4e, 0x1b, 0xbb, 0xa4, 0x23, 0x84, 0xb7, 0xe0, 0xed, 0x5a, 0x61, 0xa4, 0x0a, 0xa7,
+	0xcb, 0x69, 0xec, 0xd3, 0x31, 0x45, 0x29, 0xc0, 0x48, 0x63, 0x00, 0xb3, 0x0d, 0xa3, 0x57, 0x46,
+	0x6f, 0x8c, 0x68, 0x45, 0xc1, 0x04, 0x3d, 0x70, 0x57, 0x45, 0xe0, 0x20, 0x80, 0xf7, 0x9c, 0xbd,
+	0x94, 0x2c, 0x0f, 0xdc, 0x94, 0x83, 0x5f, 0xe6, 0xd9, 0xba, 0xb4, 0x37, 0x27, 0xbd, 0xa0, 0xaf,
+	0x09, 0x31, 0xf9, 0xd3, 0xd7, 0x02, 0xc6, 0xcb, 0xa2, 0x09, 0xc6, 0x70, 0x7e, 0x88, 0x05, 0xef,
+	0xf0, 0x32, 0x39, 0x6d, 0xe5, 0x94, 0xdc, 0x7a, 0xf6, 0x0f, 0x9e, 0x7e, 0x07, 0x00, 0x3a, 0xbe,
+	0xf8, 0xbd, 0xa0, 0x01, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -35,6 +35,14 @@ func getEnv(key string) string {
 	return val
 }
 
+func getEnvDefault(key, defaultVal string) string {
+	val, found := os.LookupEnv(key)
+	if !found {
+		return defaultVal
+	}
+	return val
+}
+
 // Stuff that should be in the configuration file, but I'm too lazy
 
 var ldapServerAddr = getEnv("LDAP_SERVER_ADDR")
@@ -46,6 +54,9 @@ var ldapSearchQuery = getEnv("LDAP_SEARCH_QUERY")
 var ldapSSHKeyFieldName = getEnv("LDAP_SSH_KEY_FIELD_NAME")
 var ldapPasswordFieldName = getEnv("LDAP_PASSWORD_FIELD_NAME")
 
+// optional, e.g. "memberOf". Groups are not reported if empty
+var ldapGroupFieldName = getEnvDefault("LDAP_GROUP_FIELD_NAME", "")
+
 var grpcServerAddr = getEnv("LDAP_GRPC_SERVER_ADDR")
 var whitelist = makeWhitelist(getEnv("LDAP_GRPC_WHITELIST"))
 
@@ -56,6 +67,29 @@ type ldapLoginServer struct {
 	ldapConn *ldap.Conn
 }
 
+func attributes(names ...string) []string {
+	if ldapGroupFieldName != "" {
+		names = append(names, ldapGroupFieldName)
+	}
+	return names
+}
+
+// Group names of the entry. DNs (cn=staff,ou=groups,...) are reduced to
+// the value of the first attribute (staff)
+func getGroups(entry *ldap.Entry) (groups []string) {
+	if ldapGroupFieldName == "" {
+		return nil
+	}
+	for _, val := range entry.GetAttributeValues(ldapGroupFieldName) {
+		dn, err := ldap.ParseDN(val)
+		if err == nil && len(dn.RDNs) > 0 && len(dn.RDNs[0].Attributes) > 0 {
+			val = dn.RDNs[0].Attributes[0].Value
+		}
+		groups = append(groups, val)
+	}
+	return
+}
+
 const passwdPrefix = "{SSHA}"
 
 func comparePassword(hashStr, password string) (equal bool, err error) {
@@ -107,7 +141,7 @@ func (s *ldapLoginServer) AuthPasswd(ctx context.Context, req *ldaplogin.PasswdA
 		ldapSearchLoc,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
 		fmt.Sprintf(ldapSearchQuery, ldap.EscapeFilter(req.GetLogin())),
-		[]string{ldapPasswordFieldName},
+		attributes(ldapPasswordFieldName),
 		nil,
 	)
 
@@ -144,6 +178,7 @@ func (s *ldapLoginServer) AuthPasswd(ctx context.Context, req *ldaplogin.PasswdA
 
 	resp = &ldaplogin.AuthReply{
 		Status: ldaplogin.AuthReply_OK,
+		Groups: getGroups(entry),
 	}
 	return
 }
@@ -177,7 +212,7 @@ func (s *ldapLoginServer) AuthKey(ctx context.Context, req *ldaplogin.KeyAuthReq
 		ldapSearchLoc,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
 		fmt.Sprintf(ldapSearchQuery, ldap.EscapeFilter(req.GetLogin())),
-		[]string{ldapSSHKeyFieldName},
+		attributes(ldapSSHKeyFieldName),
 		nil,
 	)
 
@@ -215,6 +250,7 @@ func (s *ldapLoginServer) AuthKey(ctx context.Context, req *ldaplogin.KeyAuthReq
 		if ssh.KeysEqual(userKey, authorizedKey) {
 			resp = &ldaplogin.AuthReply{
 				Status: ldaplogin.AuthReply_OK,
+				Groups: getGroups(entry),
 			}
 			return
 		}
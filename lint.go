@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Andrew-Morozko/orca/config"
+	"github.com/Andrew-Morozko/orca/orca"
+	"github.com/Andrew-Morozko/orca/orca/mydocker"
+)
+
+// orca lint-image <ref>: validates the labels of the image and prints the configuration
+// orca would use for it. Exit code is 1 if the image is invalid, so it could be used in CI.
+func lintImage(ref string) int {
+	err := loadConfig(false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	docker, err := mydocker.FromEnv(config.Get().Docker.Version)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: failed to get docker client:", err)
+		return 1
+	}
+	img, err := docker.InspectImage(context.Background(), ref)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	// same conditions as in ListLabeledImageIDs
+	if _, found := img.Get("orca.enabled"); !found {
+		fmt.Println("warning: no orca.enabled label, orca will ignore the image")
+	}
+	hasLatest := false
+	for _, tag := range img.RepoTags {
+		if strings.HasSuffix(tag, ":latest") {
+			hasLatest = true
+		}
+	}
+	if !hasLatest {
+		fmt.Println("warning: image is not tagged as latest, orca will ignore it")
+	}
+
+	oi, warnings, err := orca.ParseImage(img)
+	for _, warning := range warnings {
+		fmt.Println("warning:", warning)
+	}
+	if err != nil {
+		if labelErrs, ok := err.(orca.LabelErrors); ok {
+			for _, labelErr := range labelErrs {
+				fmt.Println("error:", labelErr)
+			}
+		} else {
+			fmt.Println("error:", err)
+		}
+		return 1
+	}
+	fmt.Println()
+	err = oi.WriteConfig(os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
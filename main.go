@@ -1,28 +1,35 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"flag"
 	"fmt"
+	"html"
 	"log"
 	"path/filepath"
 
+	"github.com/Andrew-Morozko/orca/config"
 	"github.com/Andrew-Morozko/orca/ldap/ldaplogin"
 	"github.com/Andrew-Morozko/orca/mylog"
 	"github.com/Andrew-Morozko/orca/orca"
 	"github.com/Andrew-Morozko/orca/orca/errctrl"
 	ioctrl "github.com/Andrew-Morozko/orca/orca/ioctrl"
+	"github.com/Andrew-Morozko/orca/orca/metrics"
 	"github.com/Andrew-Morozko/orca/orca/mydocker"
-	trie "github.com/Andrew-Morozko/orca/orca/search"
 	orcassh "github.com/Andrew-Morozko/orca/orca/ssh"
-	"github.com/facette/natsort"
 	"google.golang.org/grpc"
 
 	"github.com/Andrew-Morozko/orca/jobcontroller"
 
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"io"
@@ -32,20 +39,106 @@ import (
 
 	"io/ioutil"
 
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/gliderlabs/ssh"
-	"golang.org/x/crypto/ssh/terminal"
-	// "github.com/docker/docker/pkg/stdcopy"
 )
 
-func getEnv(key string) string {
-	val, found := os.LookupEnv(key)
-	if !found {
-		panic(fmt.Sprintf(`Env var named "%s" not found!`, key))
+const defaultConfigPath = "./orca.yml"
+
+var configPath = flag.String("config", "", "path to the config file (default "+defaultConfigPath+" if it exists)")
+
+// Loads the config, shared by the server and lint-image.
+// lint-image doesn't need the server settings, so it skips the validation.
+func loadConfig(validate bool) error {
+	if *configPath == "" {
+		if _, err := os.Stat(defaultConfigPath); err == nil {
+			*configPath = defaultConfigPath
+		}
+	}
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	if validate {
+		err = conf.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	config.Set(conf)
+	orca.SetMaxContainers(conf.Containers.Max)
+	return nil
+}
+
+// Sinks of the logs, closeSinks closes the files. minLevel is the lowest level
+// any sink accepts, so the logger doesn't bother with the rest
+func openLogSinks(confs []config.LogSink) (sinks mylog.Sinks, closeSinks func(), minLevel mylog.LogLevel, err error) {
+	var closers []io.Closer
+	closeAll := func() {
+		for _, closer := range closers {
+			_ = closer.Close()
+		}
+	}
+	defer func() {
+		if err != nil {
+			closeAll()
+		}
+	}()
+
+	minLevel = mylog.Fatal
+	for _, conf := range confs {
+		// validated by the config
+		format := mylog.FormatText
+		if conf.Format != "" {
+			format, _ = mylog.ParseFormat(conf.Format)
+		}
+		level := mylog.Debug
+		if conf.Level != "" {
+			level, _ = mylog.ParseLevel(conf.Level)
+		}
+		if level < minLevel {
+			minLevel = level
+		}
+
+		switch conf.Type {
+		case "stderr":
+			sinks = append(sinks, mylog.NewSink(os.Stderr, format, level))
+		case "file":
+			file, err := mylog.OpenRotatingFile(conf.Path, int64(conf.MaxSizeMB)<<20, conf.MaxBackups)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			closers = append(closers, file)
+			sinks = append(sinks, mylog.NewSink(file, format, level))
+		case "syslog":
+			tag := conf.Tag
+			if tag == "" {
+				tag = "orca"
+			}
+			sink, err := mylog.NewSyslogSink(conf.Address, tag, format, level)
+			if err != nil {
+				return nil, nil, 0, errors.WithMessage(err, "connecting to syslog")
+			}
+			closers = append(closers, sink)
+			sinks = append(sinks, sink)
+		}
 	}
-	return val
+	return sinks, closeAll, minLevel, nil
 }
 
-var grpcServerAddr = getEnv("ORCA_GRPC_LDAP_SERVER")
+// Applies the changes of the config file to the running server
+func reloadConfig(jc jobcontroller.JobController) {
+	ignored, err := config.Reload(*configPath)
+	if err != nil {
+		jc.Logger.Error.Err(err, "failed to reload config, keeping the old one")
+		return
+	}
+	orca.SetMaxContainers(config.Get().Containers.Max)
+	for _, setting := range ignored {
+		jc.Logger.Warn.Logf("%s can't be changed without a restart, ignored", setting)
+	}
+	jc.Logger.Log("Config reloaded")
+}
 
 func singleJoiningSlash(a, b string) string {
 	aslash := strings.HasSuffix(a, "/")
@@ -61,6 +154,16 @@ func singleJoiningSlash(a, b string) string {
 
 var userlist = orca.NewUserList()
 
+// Seconds before the queued web user retries
+const webQueueRefresh = 5
+
+const queuePage = `<!DOCTYPE html>
+<html>
+<head><meta http-equiv="refresh" content="%d"><title>Waiting for a container</title></head>
+<body><p>All containers are busy, you are #%s in the queue. This page will refresh automatically.</p></body>
+</html>
+`
+
 func webHandler(jc jobcontroller.JobController, shutdownReq <-chan struct{}) {
 	jc.Job.Add(1)
 	defer jc.Job.Done()
@@ -78,8 +181,14 @@ func webHandler(jc jobcontroller.JobController, shutdownReq <-chan struct{}) {
 			switch action {
 			case "redirectlogin":
 				jc.Logger.Log("Redirecting user to login")
-				redirectUrl := fmt.Sprintf(os.Getenv("ORCA_HTTP_LOGIN_URL"), url.QueryEscape("http://"+req.Host+req.RequestURI))
+				redirectUrl := fmt.Sprintf(config.Get().HTTP.LoginURL, url.QueryEscape("http://"+req.Host+req.RequestURI))
 				http.Redirect(resp, req, redirectUrl, http.StatusFound)
+			case "queued":
+				position := req.Header.Get("OrcaQueuePosition")
+				resp.Header().Set("Retry-After", strconv.Itoa(webQueueRefresh))
+				resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+				resp.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = fmt.Fprintf(resp, queuePage, webQueueRefresh, html.EscapeString(position))
 			default:
 				jc.Logger.Debug.Err(err, "Error handler error")
 				http.Error(resp, action, http.StatusInternalServerError)
@@ -95,7 +204,7 @@ func webHandler(jc jobcontroller.JobController, shutdownReq <-chan struct{}) {
 			// determine user identity
 			// "ip"/"cookie"
 			// todo: request to the server to authorize the provided cookie
-			cookieName := os.Getenv("ORCA_HTTP_USER_IDENTITY_COOKIE")
+			cookieName := config.Get().HTTP.IdentityCookie
 			cookie, err := req.Cookie(cookieName)
 			if err == http.ErrNoCookie {
 				// send to page that redirects to login page
@@ -134,21 +243,17 @@ func webHandler(jc jobcontroller.JobController, shutdownReq <-chan struct{}) {
 
 			jc.Logger.Log("Got web image ", oi.Name)
 			jc.Logger.Log("Trying to get ContainerUser")
-			var cu *orca.ContainerUser
-			var oc *orca.Container
-			var status orca.ContainerStatus
-			for i := 1; i <= maxRestarts; i++ {
-				cu = oi.GetContainerUser(jc, ui)
-				cu.Activity()
-				oc, status = cu.GetContainer()
-				if status.ContainerState != orca.ContainerStateWorking {
-					jc.Logger.Logf("Failed to get working container, got %s; retrying %d/%d", status, i, maxRestarts)
-				} else {
-					break
-				}
+			// Don't hold the request in the queue, user is asked to come back later.
+			// ContainerUser keeps the place in the queue in the meantime.
+			cu, oc, status := getWorkingContainer(jc, oi, ui, func(position int) bool {
+				req.Header.Set("OrcaQueuePosition", strconv.Itoa(position))
+				return false
+			})
+			if status.Err == orca.QueuedErr {
+				req.Header.Add("OrcaRequestAction", "queued")
+				return
 			}
 			if oc == nil {
-				jc.Logger.Fatal.Logf("Failed to get working container, got %s and ran out of retries", status)
 				req.Header.Add("OrcaRequestAction", "Failed to start the container")
 				return //500
 			}
@@ -182,7 +287,7 @@ func webHandler(jc jobcontroller.JobController, shutdownReq <-chan struct{}) {
 		},
 	}
 	// rp.ServeHTTP
-	s := http.Server{Addr: ":8080", Handler: &rp}
+	s := http.Server{Addr: config.Get().HTTP.Listen, Handler: countRequests(&rp)}
 
 	go func() {
 		jc.Job.Add(1)
@@ -214,139 +319,45 @@ func webHandler(jc jobcontroller.JobController, shutdownReq <-chan struct{}) {
 	}()
 }
 
-var userFail = errors.New("User failed to select the task")
-
-func sshMenu(rw io.ReadWriter, phs orcassh.PTYHandlerSetter, ui *orca.User) (oi *orca.Image, err error) {
-	// if !isPty {
-	// 	_, _ = io.WriteString(rw, "Can't work in a non-pty mode!\n")
-	// 	return nil, errors.New("No PTY")
-	// }
-	term := terminal.NewTerminal(rw, "Select the task: ")
-
-	// Update the terminal size
-
-	phs(func(win ssh.Window) {
-		_ = term.SetSize(win.Width, win.Height)
-	})
-
-	_, err = io.WriteString(term, "Availible tasks:\n\n")
-	if err != nil {
-		return
-	}
-
-	tasks := imageList.GetImages(orca.ImageKindSSH, ui)
-	trie := trie.New()
-
-	tasknames := make([]string, 0, len(tasks))
-	for taskname := range tasks {
-		tasknames = append(tasknames, taskname)
-	}
-	natsort.Sort(tasknames)
-
-	for _, task := range tasknames {
-		trie.Add(task)
-		_, err = io.WriteString(term, task)
-		if err != nil {
-			return
-		}
-		_, err = io.WriteString(term, "\n")
-		if err != nil {
-			return
-		}
-	}
-	_, err = io.WriteString(term, "\n")
-	if err != nil {
-		return
-	}
-
-	term.AutoCompleteCallback = func(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
-		switch key {
-		case '\t':
-			line = strings.ToLower(line)
-			gc, em := trie.Search(line)
-			if gc == "" {
-				return line, pos, false
-			}
-			newLine = line + gc
-			if em {
-				newLine += " "
-			}
-			newPos = len(newLine)
-			ok = true
-			return
-
-		default:
-			// line = strings.ToLower(line)
-			// gc, em := trie.Search(line)
-			// if gc == "" {
-			// 	return line, pos, false
-			// }
-			// newLine = line + gc
-			// if em {
-			// 	newLine += " "
-			// }
-			// newPos = len(line)
-			// ok = true
-			return line, pos, false
-		}
-
-	}
-
-	var selectedTask string
-	for attempt := 0; attempt < 3; attempt++ {
-		selectedTask, err = term.ReadLine()
-		if err != nil {
-			return
-		}
-		selectedTask = strings.ToLower(strings.TrimSpace(selectedTask))
-
-		if task, found := tasks[selectedTask]; found {
-			return task, nil
-		} else {
-			_, err = io.WriteString(term, "Not found!\n")
-			if err != nil {
-				return
-			}
-		}
-	}
-	_, err = io.WriteString(term, "Failed to select the task\n")
-	if err != nil {
-		return
-	}
-	err = userFail
-	return
-}
-
 func sshHandler(jc jobcontroller.JobController, sess *orcassh.SSHSession) {
 	defer jc.Job.Done()
 	jc = jc.NewCtx(sess.Context())
-	jc = jc.AddLoggerPrefix("SSH handler")
+	jc = jc.AddLoggerPrefix("SSH handler").AddLoggerField(mylog.FieldRemoteAddr, sess.RemoteAddr().String())
 
-	jc.Logger.Log("Got connection from ", sess.RemoteAddr())
+	jc.Logger.Log("Got connection")
+	defer countConnection("ssh")()
 
 	var err error
 	status_ExitCode := 255
+	// scripts read stdout, our messages shouldn't get there
+	msgOut := io.Writer(sess)
+	if !sess.IsPty() {
+		msgOut = sess.Stderr()
+	}
 	defer func() {
 		jc.Logger.Debug.Log("exit handler executing")
 		if jc.ShutdownStatus() >= jobcontroller.Demanded {
-			_, _ = io.WriteString(sess, ioctrl.BorderMessage(
+			_, _ = io.WriteString(msgOut, ioctrl.BorderMessage(
 				"Server is shutting down,",
 				"sorry for the inconvenience",
 			))
 		} else {
 			switch errors.Cause(err) {
 			// expected errors, ignoring them
-			case nil, userFail, io.EOF:
+			case nil, userFail, io.EOF, context.Canceled:
 				// log.Println("Container exited with, status code =", status)
 				err = nil
 			case orca.InactivityTimeoutErr:
-				_, _ = io.WriteString(sess, ioctrl.BorderMessage("Kicked out due to inactivity"))
+				_, _ = io.WriteString(msgOut, ioctrl.BorderMessage("Kicked out due to inactivity"))
 				status_ExitCode = 254
 			case orca.SessionTimeoutErr:
-				_, _ = io.WriteString(sess, ioctrl.BorderMessage("Kicked out due to session age"))
+				_, _ = io.WriteString(msgOut, ioctrl.BorderMessage("Kicked out due to session age"))
+				status_ExitCode = 254
+			case orca.KickedErr:
+				_, _ = io.WriteString(msgOut, ioctrl.BorderMessage("Kicked out by the administrator"))
 				status_ExitCode = 254
 			default:
-				_, _ = io.WriteString(sess, ioctrl.BorderMessage(
+				_, _ = io.WriteString(msgOut, ioctrl.BorderMessage(
 					"Internal server error,",
 					"sorry for the inconvenience",
 				))
@@ -364,7 +375,7 @@ func sshHandler(jc jobcontroller.JobController, sess *orcassh.SSHSession) {
 		return
 	}
 
-	jc = jc.AddLoggerPrefix(fmt.Sprintf(`user "%s"`, ui.ID))
+	jc = jc.AddLoggerField(mylog.FieldUser, ui.ID)
 
 	// Proxy data, this gives us ability to close p1/p2 without loosing
 	// the client connection (for final error reporting)
@@ -374,30 +385,20 @@ func sshHandler(jc jobcontroller.JobController, sess *orcassh.SSHSession) {
 		sessProxy.Close()
 	}()
 
-	oi, err := sshMenu(sessProxy, sess.SetPTYHandler, ui)
+	oi, unread, err := selectSSHImage(sessProxy, sess, ui)
 	if err != nil {
 		return
 	}
-	jc = jc.AddLoggerPrefix(oi.String())
+	jc = jc.AddLoggerField(mylog.FieldImage, oi.Name)
 	// _, err = io.WriteString(sess, "Launching...")
 	// if err != nil {
 	// 	return
 	// }
-	var cu *orca.ContainerUser
-	var oc *orca.Container
-	var status orca.ContainerStatus
-	for i := 1; i <= maxRestarts; i++ {
-		cu = oi.GetContainerUser(jc, ui)
-		cu.Activity()
-		oc, status = cu.GetContainer()
-		if status.ContainerState != orca.ContainerStateWorking {
-			jc.Logger.Logf("Failed to get working container, got %s; retrying %d/%d", status, i, maxRestarts)
-		} else {
-			break
-		}
-	}
+	cu, oc, status := getWorkingContainer(jc, oi, ui, func(position int) bool {
+		_, _ = fmt.Fprintf(msgOut, "All containers are busy, you are #%d in the queue\r\n", position)
+		return true
+	})
 	if oc == nil {
-		jc.Logger.Fatal.Logf("Failed to get working container, got %s and ran out of retries", status)
 		if status.Err != nil {
 			err = status.Err
 		} else {
@@ -410,24 +411,65 @@ func sshHandler(jc jobcontroller.JobController, sess *orcassh.SSHSession) {
 	// TODO: fails for multiuser containers (mirrors output), needs more complex logic
 	// TODO: although, if you just attach to out and err - you have a way to monitor what
 	// is happening in the container ;)
-	stream, err := oc.GetStream(sess.Context())
+	streamOpts := orca.StreamOptions{
+		Tty: sess.IsPty(),
+	}
+	if pty, _, isPty := sess.Pty(); isPty {
+		streamOpts.Env = []string{"TERM=" + pty.Term}
+	}
+	stream, err := oc.GetStream(sess.Context(), streamOpts)
 	if err != nil {
 		return
 	}
 	defer stream.Close()
 
-	// RN we are pty-only, so the pty data must be simply sent over the connection
-	// Some weird stuff happens if non-pty
 	cm, err := ioctrl.NewCopyMonitor(sess.Context(), ioctrl.NotificationChanel(cu.ActivityChan()))
 	if err != nil {
 		return
 	}
 
-	cm.AddCopier(sessProxy, stream.Reader)
-	cm.AddCopier(stream.Conn, sessProxy)
+	toUser := countBytes(sessProxy, "ssh", "out")
+	toContainer := countBytes(stream.Conn, "ssh", "in")
+	rec := startRecording(jc, sess, oi, ui, oc)
+	if rec != nil {
+		defer func() {
+			jc.Logger.Warn.Err(rec.Close(), "recording failed")
+		}()
+		toUser = io.MultiWriter(toUser, rec.Output())
+		toContainer = io.MultiWriter(toContainer, rec.Input())
+	}
+	// typed (or pasted) right after the task was selected
+	if len(unread) != 0 {
+		_, err = toContainer.Write(unread)
+		if err != nil {
+			return
+		}
+	}
+	if stream.Multiplexed() {
+		// no tty: stdout and stderr of the container come in frames
+		toUserErr := countBytes(sess.Stderr(), "ssh", "out")
+		if rec != nil {
+			toUserErr = io.MultiWriter(toUserErr, rec.Output())
+		}
+		cm.AddCopyFunc(func() error {
+			_, err := stdcopy.StdCopy(toUser, toUserErr, stream.Reader)
+			return err
+		})
+	} else {
+		cm.AddCopier(toUser, stream.Reader)
+	}
+	if sess.IsPty() {
+		cm.AddCopier(toContainer, sessProxy)
+	} else {
+		// piped stdin: the container gets the EOF and finishes the output
+		cm.AddHalfCloseCopier(toContainer, sessProxy, stream.CloseWrite)
+	}
 
 	sess.SetPTYHandler(func(win ssh.Window) {
-		_ = oc.ResizeTTY(sess.Context(), win.Height, win.Width)
+		_ = stream.Resize(sess.Context(), win.Height, win.Width)
+		if rec != nil {
+			rec.Resize(win.Width, win.Height)
+		}
 	})
 
 	select {
@@ -453,19 +495,214 @@ func sshHandler(jc jobcontroller.JobController, sess *orcassh.SSHSession) {
 
 		_, _, err = cm.Status()
 		err = errors.WithMessage(err, "IO Closed")
+		switch {
+		case oi.ConnectionMethod == orca.ConnectionMethodExec:
+			code, err := stream.ExitCode(jc.CleanupCtx)
+			if err == nil {
+				status_ExitCode = code
+			} else {
+				jc.Logger.Warn.Err(err, "failed to get exec exit code")
+			}
+		case oi.ConnectionMethod == orca.ConnectionMethodAttach && !sess.IsPty():
+			// scripts need the exit code, the container exits right after
+			// closing its output (unless someone else is attached)
+			select {
+			case exitStatus := <-cu.ShutdownDone():
+				if exitStatus.ContainerState == orca.ContainerStateShutdown {
+					status_ExitCode = int(exitStatus.Status)
+				}
+			case <-time.After(exitStatusTimeout):
+			}
+		}
 	}
 	return
 }
 
-const maxRestarts = 5
+// waiting for the exit code of the attached container after its output is closed
+const exitStatusTimeout = 3 * time.Second
+
+// Login could name the task: "user+task"
+func splitSSHLogin(login string) (user, task string) {
+	// everything after the first "+" is the task, it may contain "+" too
+	if i := strings.IndexByte(login, '+'); i != -1 {
+		return login[:i], login[i+1:]
+	}
+	return login, ""
+}
+
+// Assigns the user to a working container of the image, retrying on failures.
+// If the image was removed in the meantime, its replacement is used.
+// queued is called with the position in the queue if the container limit is reached,
+// if it returns false, status.Err is orca.QueuedErr.
+// The place in the queue is given up if jc is done while waiting.
+// oc is nil if no container could be started.
+func getWorkingContainer(jc jobcontroller.JobController, oi *orca.Image, ui *orca.User, queued func(position int) bool) (cu *orca.ContainerUser, oc *orca.Container, status orca.ContainerStatus) {
+	maxRestarts := config.Get().Containers.MaxRestarts
+	for i := 1; i <= maxRestarts; i++ {
+		cu = oi.GetContainerUser(jc, ui)
+		cu.Activity()
+		oc, status = cu.GetContainerQueued(jc, queued)
+		if status.ContainerState == orca.ContainerStateWorking || status.Err == orca.QueuedErr || jc.Err() != nil {
+			return
+		}
+		jc.Logger.Logf("Failed to get working container, got %s; retrying %d/%d", status, i, maxRestarts)
+		if errors.Cause(status.Err) == orca.ImageRemovedErr {
+			newOi, err := imageList.GetImage(oi.Kind, oi.Name, ui)
+			if err != nil {
+				status.Err = err
+				return
+			}
+			jc.Logger.Logf("%s was replaced by %s", oi, newOi)
+			oi = newOi
+		}
+	}
+	jc.Logger.Fatal.Logf("Failed to get working container, got %s and ran out of retries", status)
+	return
+}
+
+const tcpTokenTimeout = 30 * time.Second
+const tcpDialTimeout = 10 * time.Second
+
+func tcpHandler(jc jobcontroller.JobController, oi *orca.Image, conn net.Conn) {
+	defer jc.Job.Done()
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(jc)
+	defer cancel()
+	jc = jc.NewCtx(ctx)
+	jc = jc.AddLoggerPrefix("TCP handler").
+		AddLoggerField(mylog.FieldImage, oi.Name).
+		AddLoggerField(mylog.FieldRemoteAddr, conn.RemoteAddr().String())
+
+	jc.Logger.Log("Got connection")
+	defer countConnection("tcp")()
+
+	var err error
+	defer func() {
+		if jc.ShutdownStatus() >= jobcontroller.Demanded {
+			_, _ = io.WriteString(conn, ioctrl.BorderMessage(
+				"Server is shutting down,",
+				"sorry for the inconvenience",
+			))
+			return
+		}
+		switch errors.Cause(err) {
+		case nil, io.EOF, context.Canceled:
+		case orca.InactivityTimeoutErr:
+			_, _ = io.WriteString(conn, ioctrl.BorderMessage("Kicked out due to inactivity"))
+		case orca.SessionTimeoutErr:
+			_, _ = io.WriteString(conn, ioctrl.BorderMessage("Kicked out due to session age"))
+		case orca.KickedErr:
+			_, _ = io.WriteString(conn, ioctrl.BorderMessage("Kicked out by the administrator"))
+		case orca.ImageNotAvailibleErr:
+			_, _ = io.WriteString(conn, ioctrl.BorderMessage("Task is not availible to you"))
+		default:
+			_, _ = io.WriteString(conn, ioctrl.BorderMessage(
+				"Internal server error,",
+				"sorry for the inconvenience",
+			))
+			jc.Logger.Err(err, "connection died")
+		}
+	}()
+
+	// Buffered, so bytes read past the token line are not lost
+	connReader := bufio.NewReader(conn)
+
+	var ui *orca.User
+	switch oi.Identify {
+	case orca.TCPIdentifyIP:
+		var host string
+		host, _, err = net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil {
+			return
+		}
+		ui, err = userlist.GetUserFromAddr(host)
+	case orca.TCPIdentifyToken:
+		_ = conn.SetReadDeadline(time.Now().Add(tcpTokenTimeout))
+		_, err = io.WriteString(conn, "Token: ")
+		if err != nil {
+			return
+		}
+		var token string
+		token, err = connReader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		_ = conn.SetReadDeadline(time.Time{})
+		ui, err = userlist.GetUserByWebToken(token)
+		if err != nil {
+			jc.Logger.Err(err, "token check failed")
+			_, err = io.WriteString(conn, "Invalid token\n")
+			return
+		}
+	default:
+		err = errors.Errorf("unknown identification method %s", oi.Identify)
+	}
+	if err != nil {
+		return
+	}
+	if !oi.IsVisibleTo(ui) {
+		err = orca.ImageNotAvailibleErr
+		return
+	}
+	jc = jc.AddLoggerField(mylog.FieldUser, ui.ID)
+
+	cu, oc, status := getWorkingContainer(jc, oi, ui, func(position int) bool {
+		_, err := fmt.Fprintf(conn, "All containers are busy, you are #%d in the queue\r\n", position)
+		if err != nil {
+			// client is gone, no need to keep the place in the queue
+			cancel()
+		}
+		return true
+	})
+	if oc == nil {
+		if status.Err != nil {
+			err = status.Err
+		} else {
+			err = errors.New(status.String())
+		}
+		return
+	}
+	defer cu.NotifyConnectionClosed()
+
+	dialCtx, cancelDial := context.WithTimeout(jc, tcpDialTimeout)
+	containerConn, err := oc.Dial(dialCtx)
+	cancelDial()
+	if err != nil {
+		err = errors.WithMessage(err, "connecting to the container")
+		return
+	}
+	defer containerConn.Close()
+
+	cm, err := ioctrl.NewCopyMonitor(jc, ioctrl.NotificationChanel(cu.ActivityChan()))
+	if err != nil {
+		return
+	}
+	cm.AddCopier(countBytes(conn, "tcp", "out"), containerConn)
+	cm.AddCopier(countBytes(containerConn, "tcp", "in"), connReader)
+
+	select {
+	case exitStatus := <-cu.ShutdownDone():
+		jc.Logger.Debug.Log("Exit status: ", exitStatus)
+		switch exitStatus.ContainerState {
+		case orca.ContainerStateShutdown:
+		default:
+			err = exitStatus.Err
+		}
+	case <-cm.Done():
+		jc.Logger.Log("IO closed")
+		_, _, err = cm.Status()
+		err = errors.WithMessage(err, "IO Closed")
+	}
+}
 
 func setupSSHServer(jc jobcontroller.JobController, shutdownReq <-chan struct{}) (err error) {
 	defer errctrl.Annotate(&err, "Failed to start ssh server")
 	jc.Job.Add(1)
 	defer jc.Job.Done()
 
+	conf := config.Get()
 	ldapConn, err := grpc.Dial(
-		grpcServerAddr,
+		conf.SSH.LDAPServer,
 		grpc.WithInsecure(),
 	)
 	if err != nil {
@@ -481,29 +718,35 @@ func setupSSHServer(jc jobcontroller.JobController, shutdownReq <-chan struct{})
 	ldapClient := ldaplogin.NewLDAPLoginClient(ldapConn)
 
 	s := &ssh.Server{
-		Addr: ":22222",
+		Addr: conf.SSH.Listen,
 		PasswordHandler: func(ctx ssh.Context, pass string) (authorized bool) {
+			login, task := splitSSHLogin(ctx.User())
+			start := time.Now()
 			reply, err := ldapClient.AuthPasswd(
 				jc,
 				&ldaplogin.PasswdAuthRequest{
-					Login:    ctx.User(),
+					Login:    login,
 					Password: pass,
 				},
 			)
+			metrics.AuthDuration.WithLabelValues("password").Observe(metrics.Since(start))
 			if err != nil {
+				metrics.AuthFailures.WithLabelValues("password", "error").Inc()
 				jc.Logger.Err(err, "error in rpc call to AuthPasswd")
 				return
 			}
 			switch reply.GetStatus() {
 			case ldaplogin.AuthReply_OK:
 			case ldaplogin.AuthReply_FAILED:
-				jc.Logger.Logf(`User "%s" failed to pass password auth`, ctx.User())
+				metrics.AuthFailures.WithLabelValues("password", "denied").Inc()
+				jc.Logger.Logf(`User "%s" failed to pass password auth`, login)
 				return
 			case ldaplogin.AuthReply_SERVER_ERROR:
-				jc.Logger.Error.Logf(`Auth server error on password login by "%s"`, ctx.User())
+				metrics.AuthFailures.WithLabelValues("password", "error").Inc()
+				jc.Logger.Error.Logf(`Auth server error on password login by "%s"`, login)
 				return
 			}
-			ui, err := userlist.GetUserFromSSH(ctx.User())
+			ui, err := userlist.GetUserFromSSH(login, reply.GetGroups())
 			if err != nil {
 				jc.Logger.Err(err, "error in while fetching UserIdentity from the list")
 				return
@@ -512,6 +755,7 @@ func setupSSHServer(jc jobcontroller.JobController, shutdownReq <-chan struct{})
 				"User",
 				ui,
 			)
+			ctx.SetValue("Task", task)
 			return true
 
 		},
@@ -520,28 +764,34 @@ func setupSSHServer(jc jobcontroller.JobController, shutdownReq <-chan struct{})
 			sshHandler(jc, orcassh.Wrap(sess))
 		},
 		PublicKeyHandler: func(ctx ssh.Context, key ssh.PublicKey) (authorized bool) {
+			login, task := splitSSHLogin(ctx.User())
+			start := time.Now()
 			reply, err := ldapClient.AuthKey(
 				jc,
 				&ldaplogin.KeyAuthRequest{
-					Login:     ctx.User(),
+					Login:     login,
 					PublicKey: key.Marshal(),
 				},
 			)
+			metrics.AuthDuration.WithLabelValues("publickey").Observe(metrics.Since(start))
 			if err != nil {
+				metrics.AuthFailures.WithLabelValues("publickey", "error").Inc()
 				jc.Logger.Err(err, "error in rpc call to AuthKey")
 				return
 			}
 			switch reply.GetStatus() {
 			case ldaplogin.AuthReply_OK:
 			case ldaplogin.AuthReply_FAILED:
-				jc.Logger.Logf(`User "%s" failed to pass key auth`, ctx.User())
+				metrics.AuthFailures.WithLabelValues("publickey", "denied").Inc()
+				jc.Logger.Logf(`User "%s" failed to pass key auth`, login)
 				return
 			case ldaplogin.AuthReply_SERVER_ERROR:
-				jc.Logger.Error.Logf(`Auth server error on key auth by "%s"`, ctx.User())
+				metrics.AuthFailures.WithLabelValues("publickey", "error").Inc()
+				jc.Logger.Error.Logf(`Auth server error on key auth by "%s"`, login)
 				return
 			}
 
-			ui, err := userlist.GetUserFromSSH(ctx.User())
+			ui, err := userlist.GetUserFromSSH(login, reply.GetGroups())
 			if err != nil {
 				jc.Logger.Err(err, "error in while fetching UserIdentity from the list")
 				return
@@ -550,6 +800,7 @@ func setupSSHServer(jc jobcontroller.JobController, shutdownReq <-chan struct{})
 				"User",
 				ui,
 			)
+			ctx.SetValue("Task", task)
 			return true
 		},
 
@@ -558,7 +809,7 @@ func setupSSHServer(jc jobcontroller.JobController, shutdownReq <-chan struct{})
 	}
 	jc.Logger.Log("Loading private keys:")
 
-	files, err := filepath.Glob("./server_keys/id_*")
+	files, err := filepath.Glob(conf.SSH.HostKeys)
 	if err != nil {
 		return err
 	}
@@ -591,6 +842,7 @@ func setupSSHServer(jc jobcontroller.JobController, shutdownReq <-chan struct{})
 
 		var err error
 		// Todo check time between exits, if < x - go away, else - continue retrying
+		maxRestarts := config.Get().Containers.MaxRestarts
 		for restartNo := 1; restartNo <= maxRestarts; restartNo++ {
 			err = s.ListenAndServe()
 			if jc.IsShuttingDown() {
@@ -623,6 +875,38 @@ func setupSSHServer(jc jobcontroller.JobController, shutdownReq <-chan struct{})
 var imageList *orca.ImageList
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: orca [-config orca.yml] [lint-image <image>]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "lint-image":
+			if len(args) != 2 {
+				fmt.Fprintln(os.Stderr, "Usage: orca [-config orca.yml] lint-image <image>")
+				os.Exit(2)
+			}
+			os.Exit(lintImage(args[1]))
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command \"%s\"\n", args[0])
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+
+	// before the logger, it's configured there
+	err := loadConfig(true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		os.Exit(1)
+	}
+	sinks, closeSinks, minLevel, err := openLogSinks(config.Get().Log.Sinks)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to set up logging:", err)
+		os.Exit(1)
+	}
+
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println("Unexpected server shutdown!")
@@ -635,14 +919,21 @@ func main() {
 		}
 	}()
 
-	var err error
-
+	// validated
+	overflow, _ := mylog.ParseOverflowPolicy(config.Get().Log.Overflow)
 	logCtx, cancelLogCtx := context.WithCancel(context.Background())
+	log, msgChan := mylog.NewBaseLoggerWithPolicy(logCtx, minLevel, config.Get().Log.Buffer, overflow)
+	metrics.RegisterDroppedLogs(log.Dropped)
+	logFlushed := make(chan struct{})
 	defer func() {
-		time.Sleep(5 * time.Millisecond)
+		// everything logged till now gets to the sinks
 		cancelLogCtx()
+		select {
+		case <-logFlushed:
+		case <-time.After(5 * time.Second):
+			fmt.Fprintln(os.Stderr, "Timed out flushing the logs")
+		}
 	}()
-	log, msgChan := mylog.NewBaseLogger(logCtx, mylog.Debug, 200)
 
 	sc, err := jobcontroller.New(
 		logCtx,
@@ -655,14 +946,21 @@ func main() {
 	}
 
 	go func() {
-		mw := mylog.NewMessageWriter(os.Stderr)
-
+		defer close(logFlushed)
 		for {
 			msg, more := <-msgChan
 			if !more {
+				if dropped := log.Dropped(); dropped != 0 {
+					_ = sinks.WriteMessage(&mylog.Message{
+						Level:   mylog.Warn,
+						Time:    time.Now(),
+						Content: fmt.Sprintf("%d log messages were dropped", dropped),
+					})
+				}
+				closeSinks()
 				return
 			}
-			_ = mw.WriteMessage(msg)
+			_ = sinks.WriteMessage(msg)
 			if msg.Level == mylog.Fatal {
 				// Trying to reload server to undo whatever has gone wrong
 				sc.Demand()
@@ -675,32 +973,43 @@ func main() {
 	jc := sc.GetJobController(log)
 	shutdownReq := sc.ShutdownRequested()
 
-	// reloadChan := make(chan os.Signal, 1)
-	// signal.Notify(reloadChan, syscall.SIGUSR1)
-
-	// go func() {
-	// 	for {
-	// 		select {
-	// 		case <-reloadChan:
-	// 			// reload config/containers
-	// 			// loadContainers(ctx, docker)
-	// 			// TODO: reload containers on event.
-	// 		}
-
-	// 	}
-	// }()
+	if *configPath != "" {
+		log.Logf("Loaded config from %s", *configPath)
+	}
 
-	orca.Docker, err = mydocker.FromEnv()
+	orca.Docker, err = mydocker.FromEnv(config.Get().Docker.Version)
 	if err != nil {
 		log.Fatal.Err(err, "failed to get docker client")
 		return
 	}
 
+	orca.TCPConnHandler = tcpHandler
 	imageList, err = orca.NewImageList(jc)
 	if err != nil {
 		log.Fatal.Err(err, "failed to get docker client")
 		return
 	}
+
+	// Images are reloaded on docker events, SIGUSR1 forces the rescan
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGUSR1)
+	go func() {
+		for range reloadChan {
+			log.Log("Got SIGUSR1, reloading images")
+			imageList.Reload()
+		}
+	}()
+
+	// SIGHUP reloads the config
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			log.Log("Got SIGHUP, reloading config")
+			reloadConfig(jc)
+		}
+	}()
+
 	log.Log("Setting up servers")
 
 	err = setupSSHServer(jc, shutdownReq)
@@ -715,6 +1024,10 @@ func main() {
 		return
 	}
 
+	adminHandler(jc, shutdownReq)
+	metricsHandler(jc, shutdownReq)
+	recordingsJanitor(jc, shutdownReq)
+
 	_ = ioutil.WriteFile("./orca.pid", []byte(fmt.Sprintf("%d", os.Getpid())), 0664)
 
 	<-sc.Done()
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestSplitSSHLogin(t *testing.T) {
+	for _, tc := range []struct{ login, user, task string }{
+		{"alice", "alice", ""},
+		{"alice+pwn1", "alice", "pwn1"},
+		{"alice+c++", "alice", "c++"},
+		{"alice+", "alice", ""},
+	} {
+		user, task := splitSSHLogin(tc.login)
+		if user != tc.user || task != tc.task {
+			t.Errorf("%q: got %q, %q", tc.login, user, task)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Andrew-Morozko/orca/config"
+	"github.com/Andrew-Morozko/orca/jobcontroller"
+	"github.com/Andrew-Morozko/orca/orca/ioctrl"
+	"github.com/Andrew-Morozko/orca/orca/metrics"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Counts the connection, returned func records its duration:
+// defer countConnection("ssh")()
+func countConnection(kind string) func() {
+	start := time.Now()
+	metrics.Connections.WithLabelValues(kind).Inc()
+	return func() {
+		metrics.ConnectionDuration.WithLabelValues(kind).Observe(metrics.Since(start))
+	}
+}
+
+func countBytes(w io.Writer, kind, direction string) io.Writer {
+	counter := metrics.ProxiedBytes.WithLabelValues(kind, direction)
+	return &ioctrl.CountingWriter{
+		Writer: w,
+		Count: func(n int) {
+			counter.Add(float64(n))
+		},
+	}
+}
+
+func countRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		defer countConnection("http")()
+		h.ServeHTTP(resp, req)
+	})
+}
+
+func metricsHandler(jc jobcontroller.JobController, shutdownReq <-chan struct{}) {
+	addr := config.Get().Metrics.Listen
+	if addr == "" {
+		return
+	}
+	jc = jc.AddLoggerPrefix("Metrics")
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	s := http.Server{Addr: addr, Handler: mux}
+
+	jc.Job.Add(1)
+	go func() {
+		defer jc.Job.Done()
+		jc.Logger.Log("Starting metrics server on ", s.Addr)
+		go func() {
+			err := s.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				jc.Logger.Fatal.Err(err)
+			}
+		}()
+
+		select {
+		case <-shutdownReq:
+			err := s.Shutdown(jc.ShutdownCtx)
+			if err != nil {
+				s.Close()
+			}
+		case <-jc.Done():
+			s.Close()
+		}
+	}()
+}
@@ -0,0 +1,140 @@
+package mylog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Format uint8
+
+const (
+	// 2006/01/02 15:04:05 [INFO]  prefix: message key=value
+	FormatText Format = iota
+	// one object per line: time, level, prefix, msg and the fields
+	FormatJSON
+	// like text, but without the time and the level (syslog has them)
+	FormatBareText
+)
+
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return 0, fmt.Errorf(`unknown log format "%s"`, name)
+}
+
+// Appends the encoded message to buf
+func (f Format) Append(buf []byte, msg *Message) []byte {
+	switch f {
+	case FormatJSON:
+		return appendJSON(buf, msg)
+	case FormatBareText:
+		return appendText(buf, msg, false)
+	default:
+		return appendText(buf, msg, true)
+	}
+}
+
+func fieldValue(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(val)
+}
+
+func appendText(buf []byte, msg *Message, withHeader bool) []byte {
+	if withHeader {
+		year, month, day := msg.Time.Date()
+		itoa(&buf, year, 4)
+		buf = append(buf, '/')
+		itoa(&buf, int(month), 2)
+		buf = append(buf, '/')
+		itoa(&buf, day, 2)
+		buf = append(buf, ' ')
+
+		hour, min, sec := msg.Time.Clock()
+		itoa(&buf, hour, 2)
+		buf = append(buf, ':')
+		itoa(&buf, min, 2)
+		buf = append(buf, ':')
+		itoa(&buf, sec, 2)
+
+		buf = append(buf, ' ')
+		buf = append(buf, msg.Level.String()...)
+		buf = append(buf, ' ')
+	}
+
+	for _, pref := range msg.Prefixes {
+		buf = append(buf, pref...)
+		buf = append(buf, ": "...)
+	}
+	buf = append(buf, strings.TrimSuffix(msg.Content, "\n")...)
+
+	for _, field := range msg.Fields {
+		buf = append(buf, ' ')
+		buf = append(buf, field.Key...)
+		buf = append(buf, '=')
+		val := fieldValue(field.Value)
+		if val == "" || strings.ContainsAny(val, " \t\n\"=") {
+			buf = strconv.AppendQuote(buf, val)
+		} else {
+			buf = append(buf, val...)
+		}
+	}
+	return append(buf, '\n')
+}
+
+// Keys of the message itself, fields with these keys get "field_" prepended
+var jsonReservedKeys = map[string]bool{
+	"time": true, "level": true, "prefix": true, "msg": true,
+}
+
+func appendJSONString(buf []byte, s string) []byte {
+	// can't fail for a string
+	encoded, _ := json.Marshal(s)
+	return append(buf, encoded...)
+}
+
+func appendJSON(buf []byte, msg *Message) []byte {
+	buf = append(buf, `{"time":`...)
+	buf = appendJSONString(buf, msg.Time.Format(time.RFC3339Nano))
+	buf = append(buf, `,"level":`...)
+	buf = appendJSONString(buf, msg.Level.Name())
+	if len(msg.Prefixes) != 0 {
+		buf = append(buf, `,"prefix":`...)
+		buf = appendJSONString(buf, strings.Join(msg.Prefixes, ": "))
+	}
+	buf = append(buf, `,"msg":`...)
+	buf = appendJSONString(buf, strings.TrimSuffix(msg.Content, "\n"))
+
+	for _, field := range msg.Fields {
+		key := field.Key
+		if jsonReservedKeys[key] {
+			key = "field_" + key
+		}
+		buf = append(buf, ',')
+		buf = appendJSONString(buf, key)
+		buf = append(buf, ':')
+		switch field.Value.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, bool:
+			encoded, err := json.Marshal(field.Value)
+			if err == nil {
+				buf = append(buf, encoded...)
+				continue
+			}
+		}
+		buf = appendJSONString(buf, fieldValue(field.Value))
+	}
+	return append(buf, "}\n"...)
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,6 +36,39 @@ func (ll LogLevel) String() string {
 	}
 }
 
+var levelNames = []string{"debug", "info", "warn", "error", "fatal"}
+
+// Lowercase name, as used in the json and in the config
+func (ll LogLevel) Name() string {
+	if ll < 5 {
+		return levelNames[ll]
+	}
+	return "unknown"
+}
+
+func ParseLevel(name string) (LogLevel, error) {
+	for ll, llName := range levelNames {
+		if strings.EqualFold(name, llName) {
+			return LogLevel(ll), nil
+		}
+	}
+	return 0, fmt.Errorf(`unknown log level "%s"`, name)
+}
+
+// Structured context of the message
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Common field keys
+const (
+	FieldUser       = "user"
+	FieldImage      = "image"
+	FieldContainer  = "container"
+	FieldRemoteAddr = "remote_addr"
+)
+
 type Message struct {
 	Level   LogLevel
 	Logger  *Logger
@@ -40,6 +76,9 @@ type Message struct {
 	Content string
 	// Error error ?
 
+	// of the logger, at the time of sending
+	Prefixes []string
+	Fields   []Field
 }
 
 // from stdlib log
@@ -59,14 +98,41 @@ func itoa(buf *[]byte, i int, wid int) {
 	*buf = append(*buf, b[bp:]...)
 }
 
+// Destination of the messages
+type Sink interface {
+	WriteMessage(msg *Message) error
+}
+
+// Writes every message to all of the sinks
+type Sinks []Sink
+
+func (sinks Sinks) WriteMessage(msg *Message) (err error) {
+	for _, sink := range sinks {
+		if err2 := sink.WriteMessage(msg); err2 != nil && err == nil {
+			err = err2
+		}
+	}
+	return
+}
+
+// Sink writing the messages at or above Level to the io.Writer
 type MessageWriter struct {
-	buf []byte
-	out io.Writer
+	buf    []byte
+	out    io.Writer
+	Level  LogLevel
+	Format Format
 }
 
+// Text messages of all levels
 func NewMessageWriter(out io.Writer) *MessageWriter {
+	return NewSink(out, FormatText, Debug)
+}
+
+func NewSink(out io.Writer, format Format, level LogLevel) *MessageWriter {
 	return &MessageWriter{
-		out: out,
+		out:    out,
+		Level:  level,
+		Format: format,
 	}
 }
 
@@ -84,34 +150,10 @@ func (mw *MessageWriter) WaitAndWrite(msgChan <-chan *Message) (err error) {
 }
 
 func (mw *MessageWriter) WriteMessage(msg *Message) (err error) {
-	year, month, day := msg.Time.Date()
-	itoa(&mw.buf, year, 4)
-	mw.buf = append(mw.buf, '/')
-	itoa(&mw.buf, int(month), 2)
-	mw.buf = append(mw.buf, '/')
-	itoa(&mw.buf, day, 2)
-	mw.buf = append(mw.buf, ' ')
-
-	hour, min, sec := msg.Time.Clock()
-	itoa(&mw.buf, hour, 2)
-	mw.buf = append(mw.buf, ':')
-	itoa(&mw.buf, min, 2)
-	mw.buf = append(mw.buf, ':')
-	itoa(&mw.buf, sec, 2)
-
-	mw.buf = append(mw.buf, ' ')
-	mw.buf = append(mw.buf, msg.Level.String()...)
-	mw.buf = append(mw.buf, ' ')
-
-	for _, pref := range msg.Logger.prefixes {
-		mw.buf = append(mw.buf, pref...)
-		mw.buf = append(mw.buf, ": "...)
-	}
-	mw.buf = append(mw.buf, msg.Content...)
-
-	if msg.Content[len(msg.Content)-1] != '\n' {
-		mw.buf = append(mw.buf, '\n')
+	if msg.Level < mw.Level {
+		return nil
 	}
+	mw.buf = mw.Format.Append(mw.buf, msg)
 	_, err = mw.out.Write(mw.buf)
 	mw.buf = mw.buf[:0]
 	return
@@ -189,11 +231,87 @@ func newMsgFormatter(forLogger *Logger, withLevel LogLevel) MsgFormatter {
 	}
 }
 
+// What happens to the message when the buffer is full
+type OverflowPolicy uint8
+
+const (
+	// sender waits for the space in the buffer
+	OverflowBlock OverflowPolicy = iota
+	// the oldest buffered message is thrown away
+	OverflowDropOldest
+	// the message being sent is thrown away
+	OverflowDropNewest
+)
+
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch strings.ToLower(name) {
+	case "block":
+		return OverflowBlock, nil
+	case "drop_oldest":
+		return OverflowDropOldest, nil
+	case "drop_newest":
+		return OverflowDropNewest, nil
+	}
+	return 0, fmt.Errorf(`unknown log overflow policy "%s"`, name)
+}
+
+// Shared by the base logger and all derived ones
+type queue struct {
+	// first for the 64-bit alignment of atomics
+	dropped uint64
+	policy  OverflowPolicy
+	// senders hold the read lock, so the channel is never closed under them
+	lock    sync.RWMutex
+	closed  bool
+	msgChan chan *Message
+}
+
+func (q *queue) put(msg *Message) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	if q.closed {
+		atomic.AddUint64(&q.dropped, 1)
+		return
+	}
+	// fatal messages trigger the restart, they can't be lost
+	if q.policy == OverflowBlock || msg.Level == Fatal {
+		q.msgChan <- msg
+		return
+	}
+	for {
+		select {
+		case q.msgChan <- msg:
+			return
+		default:
+		}
+		if q.policy == OverflowDropNewest || cap(q.msgChan) == 0 {
+			atomic.AddUint64(&q.dropped, 1)
+			return
+		}
+		select {
+		case <-q.msgChan:
+			atomic.AddUint64(&q.dropped, 1)
+		default:
+			// the reader got there first, retry
+		}
+	}
+}
+
+func (q *queue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.msgChan)
+	}
+}
+
 type Logger struct {
 	MsgFormatter
 	Level    LogLevel
 	prefixes []string
-	msgChan  chan<- *Message
+	fields   []Field
+	q        *queue
 
 	Debug MsgFormatter
 	Info  MsgFormatter
@@ -204,18 +322,20 @@ type Logger struct {
 }
 
 func (logger *Logger) send(level LogLevel, content string) {
-	logger.msgChan <- &Message{
-		Level:   level,
-		Logger:  logger,
-		Time:    time.Now(),
-		Content: content,
-	}
+	logger.q.put(&Message{
+		Level:    level,
+		Logger:   logger,
+		Time:     time.Now(),
+		Content:  content,
+		Prefixes: logger.prefixes,
+		Fields:   logger.fields,
+	})
 }
 
-func newLogger(level LogLevel, msgChan chan<- *Message) *Logger {
+func newLogger(level LogLevel, q *queue) *Logger {
 	l := &Logger{
-		Level:   level,
-		msgChan: msgChan,
+		Level: level,
+		q:     q,
 	}
 
 	l.Debug = newMsgFormatter(l, Debug)
@@ -231,22 +351,63 @@ func newLogger(level LogLevel, msgChan chan<- *Message) *Logger {
 }
 
 func NewBaseLogger(ctx context.Context, level LogLevel, msgBufLen int) (*Logger, <-chan *Message) {
-	var msgChan chan *Message
-	if msgBufLen > 0 {
-		msgChan = make(chan *Message, msgBufLen)
-	} else {
-		msgChan = make(chan *Message)
+	return NewBaseLoggerWithPolicy(ctx, level, msgBufLen, OverflowBlock)
+}
+
+// Message channel is closed after ctx is done (or Close is called), reader
+// must drain it till then: all messages sent before that are delivered.
+// Messages sent after it are dropped.
+func NewBaseLoggerWithPolicy(ctx context.Context, level LogLevel, msgBufLen int, policy OverflowPolicy) (*Logger, <-chan *Message) {
+	if msgBufLen < 0 {
+		msgBufLen = 0
+	}
+	q := &queue{
+		policy:  policy,
+		msgChan: make(chan *Message, msgBufLen),
 	}
 	go func() {
 		<-ctx.Done()
-		close(msgChan)
+		q.close()
 	}()
 
-	return newLogger(level, msgChan), msgChan
+	return newLogger(level, q), q.msgChan
+}
+
+// Closes the message channel of the base logger, same as cancelling its ctx
+func (logger *Logger) Close() {
+	logger.q.close()
+}
+
+// Number of messages thrown away because of the overflow or sent after the close
+func (logger *Logger) Dropped() uint64 {
+	return atomic.LoadUint64(&logger.q.dropped)
+}
+
+// Prefixes and fields are never modified in place, so they are shared
+// by the loggers and the messages
+func (logger *Logger) derive() *Logger {
+	l := newLogger(logger.Level, logger.q)
+	l.prefixes = logger.prefixes
+	l.fields = logger.fields
+	return l
 }
 
 func (logger *Logger) NewWithPrefix(prefix string) *Logger {
-	l := newLogger(logger.Level, logger.msgChan)
-	l.prefixes = append(logger.prefixes, prefix)
+	l := logger.derive()
+	l.prefixes = append(logger.prefixes[:len(logger.prefixes):len(logger.prefixes)], prefix)
+	return l
+}
+
+// Field is added to every message of the new logger, value of the existing
+// field with the same key is replaced
+func (logger *Logger) WithField(key string, value interface{}) *Logger {
+	l := logger.derive()
+	l.fields = make([]Field, 0, len(logger.fields)+1)
+	for _, field := range logger.fields {
+		if field.Key != key {
+			l.fields = append(l.fields, field)
+		}
+	}
+	l.fields = append(l.fields, Field{Key: key, Value: value})
 	return l
 }
@@ -1,20 +1,144 @@
 package mylog
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLogger(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	bl, msgChan := NewBaseLogger(ctx, Debug, 10)
-	mw := NewMessageWriter(os.Stderr)
-	go mw.WaitAndWrite(msgChan)
+	var out bytes.Buffer
+	mw := NewMessageWriter(&out)
+	done := make(chan struct{})
+	go func() {
+		mw.WaitAndWrite(msgChan)
+		close(done)
+	}()
 
 	bl.Info.Log("Hello")
-	bl.Log("Hello")
+	bl.NewWithPrefix("pref").Log("Hello")
 	bl.Fatal.Log("World")
 	cancel()
-	t.Fail()
+	<-done
+	bl.Log("Too late")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 ||
+		!strings.HasSuffix(lines[0], "[INFO]  Hello") ||
+		!strings.HasSuffix(lines[1], "[INFO]  pref: Hello") ||
+		!strings.HasSuffix(lines[2], "[FATAL] World") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	if bl.Dropped() != 1 {
+		t.Errorf("dropped %d, expected 1", bl.Dropped())
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		policy   OverflowPolicy
+		expected []string
+	}{
+		{OverflowDropOldest, []string{"3", "4"}},
+		{OverflowDropNewest, []string{"1", "2"}},
+	} {
+		bl, msgChan := NewBaseLoggerWithPolicy(context.Background(), Debug, 2, tc.policy)
+		for _, content := range []string{"1", "2", "3", "4"} {
+			bl.Log(content)
+		}
+		bl.Close()
+		var got []string
+		for msg := range msgChan {
+			got = append(got, msg.Content)
+		}
+		if !reflect.DeepEqual(got, tc.expected) || bl.Dropped() != 2 {
+			t.Errorf("policy %d: got %v, dropped %d", tc.policy, got, bl.Dropped())
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	logger := newLogger(Debug, nil).NewWithPrefix("SSH handler").
+		WithField(FieldUser, "alice").
+		WithField(FieldImage, "my task").
+		WithField("level", 3)
+	msg := &Message{
+		Level:    Warn,
+		Time:     time.Date(2019, 11, 5, 13, 4, 5, 0, time.UTC),
+		Content:  "Got connection\n",
+		Prefixes: logger.prefixes,
+		Fields:   logger.fields,
+	}
+
+	text := string(FormatText.Append(nil, msg))
+	expected := "2019/11/05 13:04:05 [WARN]  SSH handler: Got connection user=alice image=\"my task\" level=3\n"
+	if text != expected {
+		t.Errorf("text:\n%q\nexpected:\n%q", text, expected)
+	}
+
+	var decoded map[string]interface{}
+	err := json.Unmarshal(FormatJSON.Append(nil, msg), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedJSON := map[string]interface{}{
+		"time":        "2019-11-05T13:04:05Z",
+		"level":       "warn",
+		"prefix":      "SSH handler",
+		"msg":         "Got connection",
+		"user":        "alice",
+		"image":       "my task",
+		"field_level": 3.0,
+	}
+	if !reflect.DeepEqual(decoded, expectedJSON) {
+		t.Errorf("json: %v", decoded)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "orca.log")
+
+	rf, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = rf.Write([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rf.Close()
+
+	expected := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for name, content := range expected {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, expected %q", name, data, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("too many backups are kept")
+	}
 }
@@ -0,0 +1,105 @@
+package mylog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Log file that is renamed to path.1 (path.1 to path.2 and so on) once it
+// grows over MaxSize bytes. Only MaxBackups old files are kept.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		Path:       path,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+	}
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *RotatingFile) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", rf.Path, n)
+}
+
+func (rf *RotatingFile) rotate() error {
+	err := rf.file.Close()
+	rf.file = nil
+	if err != nil {
+		return err
+	}
+	if rf.MaxBackups > 0 {
+		_ = os.Remove(rf.backupName(rf.MaxBackups))
+		for n := rf.MaxBackups - 1; n > 0; n-- {
+			_ = os.Rename(rf.backupName(n), rf.backupName(n+1))
+		}
+		err = os.Rename(rf.Path, rf.backupName(1))
+	} else {
+		err = os.Remove(rf.Path)
+	}
+	if err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) Write(p []byte) (n int, err error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	if rf.file == nil {
+		// previous rotation failed, try again
+		err = rf.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	// single message is never split between the files
+	if rf.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxSize {
+		err = rf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = rf.file.Write(p)
+	rf.size += int64(n)
+	return
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
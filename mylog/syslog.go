@@ -0,0 +1,67 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package mylog
+
+import (
+	"log/syslog"
+	"strings"
+)
+
+// Sink sending the messages at or above Level to syslog with the matching severity
+type SyslogSink struct {
+	Level LogLevel
+	// FormatBareText or FormatJSON
+	Format Format
+	w      *syslog.Writer
+	buf    []byte
+}
+
+// Local syslog if address is empty, otherwise "network://host:port"
+// (e.g. "udp://logs:514")
+func NewSyslogSink(address, tag string, format Format, level LogLevel) (*SyslogSink, error) {
+	network := ""
+	if address != "" {
+		if i := strings.Index(address, "://"); i != -1 {
+			network, address = address[:i], address[i+3:]
+		} else {
+			network = "udp"
+		}
+	}
+	if format == FormatText {
+		format = FormatBareText
+	}
+	w, err := syslog.Dial(network, address, syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{
+		Level:  level,
+		Format: format,
+		w:      w,
+	}, nil
+}
+
+func (ss *SyslogSink) WriteMessage(msg *Message) error {
+	if msg.Level < ss.Level {
+		return nil
+	}
+	ss.buf = ss.Format.Append(ss.buf[:0], msg)
+	line := strings.TrimSuffix(string(ss.buf), "\n")
+	switch msg.Level {
+	case Debug:
+		return ss.w.Debug(line)
+	case Info:
+		return ss.w.Info(line)
+	case Warn:
+		return ss.w.Warning(line)
+	case Error:
+		return ss.w.Err(line)
+	default:
+		return ss.w.Crit(line)
+	}
+}
+
+func (ss *SyslogSink) Close() error {
+	return ss.w.Close()
+}
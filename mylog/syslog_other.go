@@ -0,0 +1,18 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package mylog
+
+import "errors"
+
+type SyslogSink struct {
+	Sink
+}
+
+func NewSyslogSink(address, tag string, format Format, level LogLevel) (*SyslogSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+func (ss *SyslogSink) Close() error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package orca
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Andrew-Morozko/orca/orca/mydocker"
+)
+
+// Who and when can see the image
+type accessPolicy struct {
+	// nil if not restricted. If both are set, user has to be in either one
+	users  map[string]bool
+	groups map[string]bool
+	// zero if not restricted
+	after  time.Time
+	before time.Time
+}
+
+func parseList(val string) map[string]bool {
+	res := make(map[string]bool)
+	for _, item := range strings.Split(val, ",") {
+		item = mydocker.Normalise(item)
+		if item != "" {
+			res[item] = true
+		}
+	}
+	return res
+}
+
+func parseAccessPolicy(lp *labelParser) (ap accessPolicy) {
+	if val, found := lp.Lookup("orca.access.users"); found {
+		ap.users = parseList(val)
+	}
+	if val, found := lp.Lookup("orca.access.groups"); found {
+		ap.groups = parseList(val)
+	}
+	// Raw: RFC3339 is case sensitive
+	var err error
+	if val, found := lp.Lookup("orca.access.after"); found {
+		ap.after, err = time.Parse(time.RFC3339, val)
+		if err != nil {
+			lp.Errorf(`orca.access.after: invalid RFC3339 time "%s"`, val)
+		}
+	}
+	if val, found := lp.Lookup("orca.access.before"); found {
+		ap.before, err = time.Parse(time.RFC3339, val)
+		if err != nil {
+			lp.Errorf(`orca.access.before: invalid RFC3339 time "%s"`, val)
+		}
+	}
+	if !ap.after.IsZero() && !ap.before.IsZero() && !ap.after.Before(ap.before) {
+		lp.Errorf("orca.access.after is not before orca.access.before")
+	}
+	return
+}
+
+func (ap *accessPolicy) allows(ui *User, now time.Time) bool {
+	if !ap.after.IsZero() && now.Before(ap.after) {
+		return false
+	}
+	if !ap.before.IsZero() && !now.Before(ap.before) {
+		return false
+	}
+	if ap.users == nil && ap.groups == nil {
+		return true
+	}
+	if ui == nil {
+		return false
+	}
+	if ap.users[mydocker.Normalise(ui.ID)] {
+		return true
+	}
+	for _, group := range ui.Groups() {
+		if ap.groups[mydocker.Normalise(group)] {
+			return true
+		}
+	}
+	return false
+}
+
+func (oi *Image) IsVisibleTo(ui *User) bool {
+	return oi.access.allows(ui, time.Now())
+}
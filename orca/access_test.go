@@ -0,0 +1,57 @@
+package orca
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	now := time.Date(2019, 11, 1, 12, 0, 0, 0, time.UTC)
+	alice := &User{ID: "Alice"}
+	bob := &User{ID: "bob", groups: []string{"Staff"}}
+	eve := &User{ID: "eve"}
+
+	cases := []struct {
+		name    string
+		ap      accessPolicy
+		allowed map[*User]bool
+	}{
+		{
+			name:    "unrestricted",
+			ap:      accessPolicy{},
+			allowed: map[*User]bool{alice: true, bob: true, eve: true, nil: true},
+		},
+		{
+			name:    "users",
+			ap:      accessPolicy{users: parseList("alice")},
+			allowed: map[*User]bool{alice: true, bob: false, eve: false, nil: false},
+		},
+		{
+			name:    "users or groups",
+			ap:      accessPolicy{users: parseList("alice"), groups: parseList("staff, admins")},
+			allowed: map[*User]bool{alice: true, bob: true, eve: false},
+		},
+		{
+			name:    "not yet",
+			ap:      accessPolicy{after: now.Add(time.Hour)},
+			allowed: map[*User]bool{alice: false, bob: false},
+		},
+		{
+			name:    "within window",
+			ap:      accessPolicy{after: now.Add(-time.Hour), before: now.Add(time.Hour), groups: parseList("staff")},
+			allowed: map[*User]bool{alice: false, bob: true},
+		},
+		{
+			name:    "already over",
+			ap:      accessPolicy{before: now},
+			allowed: map[*User]bool{alice: false},
+		},
+	}
+	for _, c := range cases {
+		for ui, expected := range c.allowed {
+			if res := c.ap.allows(ui, now); res != expected {
+				t.Errorf("%s: allows(%v) = %v, expected %v", c.name, ui, res, expected)
+			}
+		}
+	}
+}
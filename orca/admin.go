@@ -0,0 +1,252 @@
+package orca
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Snapshots of the state owned by the manage* goroutines, for the admin API
+
+type ContainerUserInfo struct {
+	User        string
+	ContainerID string // empty while starting
+	Status      ContainerStatus
+	Connections int
+	// 0 if not waiting for the container
+	QueuePosition int
+	SessionLeft   time.Duration
+	InactiveLeft  time.Duration
+}
+
+type ContainerInfo struct {
+	DockerID        string
+	StartedAt       time.Time
+	ConcurrentUsers int
+	TotalUsers      int
+	ReservedUsers   int
+	Warm            bool
+	// not taking new users
+	EndOfLife bool
+	// IDs of the users
+	Users []string
+}
+
+type ImageInfo struct {
+	Kind     ImageKind
+	Name     string
+	DockerID string
+	// replaced, deleted or drained, serving only the current users
+	Removed    bool
+	Containers []ContainerInfo
+	Users      []ContainerUserInfo
+}
+
+var ContainerNotFoundErr = errors.New("container not found")
+
+// Gone before the request was handled
+var containerGoneErr = errors.New("container is gone")
+var containerUserGoneErr = errors.New("container user is gone")
+
+func (oc *Container) info(ctx context.Context) (info ContainerInfo, err error) {
+	reply := make(chan ContainerInfo, 1)
+	select {
+	case oc.infoC <- reply:
+		return <-reply, nil
+	case <-oc.doneC:
+		return info, containerGoneErr
+	case <-ctx.Done():
+		return info, ctx.Err()
+	}
+}
+
+// Container is stopped, its users are disconnected
+func (oc *Container) Stop() error {
+	select {
+	case <-oc.doneC:
+		return containerGoneErr
+	default:
+	}
+	select {
+	case oc.stopC <- struct{}{}:
+	default:
+		// already stopping
+	}
+	return nil
+}
+
+var containerStopTimeout = 10 * time.Second
+
+func (cu *ContainerUser) info(ctx context.Context) (info ContainerUserInfo, err error) {
+	reply := make(chan ContainerUserInfo, 1)
+	select {
+	case cu.infoC <- reply:
+		return <-reply, nil
+	case <-cu.abandonedC:
+		return info, containerUserGoneErr
+	case <-ctx.Done():
+		return info, ctx.Err()
+	}
+}
+
+// Ends the session of the user, as if it has timed out.
+// False if the session is already over.
+func (cu *ContainerUser) Kick() bool {
+	reply := make(chan bool, 1)
+	select {
+	case cu.kickC <- reply:
+		return <-reply
+	case <-cu.abandonedC:
+		return false
+	}
+}
+
+func (oi *Image) listContainers() []*Container {
+	oi.containersLock.Lock()
+	defer oi.containersLock.Unlock()
+	containers := make([]*Container, 0, len(oi.containers))
+	for _, oc := range oi.containers {
+		containers = append(containers, oc)
+	}
+	return containers
+}
+
+func (oi *Image) listContainerUsers() []*ContainerUser {
+	oi.containerLock.Lock()
+	defer oi.containerLock.Unlock()
+	cus := make([]*ContainerUser, 0, len(oi.containerUsersByUID))
+	for _, cu := range oi.containerUsersByUID {
+		cus = append(cus, cu)
+	}
+	return cus
+}
+
+// Containers and users that are gone while the snapshot is taken are skipped
+func (oi *Image) Info(ctx context.Context) (info ImageInfo, err error) {
+	info = ImageInfo{
+		Kind:     oi.Kind,
+		Name:     oi.Name,
+		DockerID: oi.DockerID,
+		Removed:  oi.IsRemoved(),
+	}
+	for _, oc := range oi.listContainers() {
+		ocInfo, err := oc.info(ctx)
+		if err == containerGoneErr {
+			continue
+		} else if err != nil {
+			return info, err
+		}
+		sort.Strings(ocInfo.Users)
+		info.Containers = append(info.Containers, ocInfo)
+	}
+	for _, cu := range oi.listContainerUsers() {
+		cuInfo, err := cu.info(ctx)
+		if err == containerUserGoneErr {
+			continue
+		} else if err != nil {
+			return info, err
+		}
+		info.Users = append(info.Users, cuInfo)
+	}
+	sort.Slice(info.Containers, func(i, j int) bool {
+		return info.Containers[i].StartedAt.Before(info.Containers[j].StartedAt)
+	})
+	sort.Slice(info.Users, func(i, j int) bool {
+		return info.Users[i].User < info.Users[j].User
+	})
+	return info, nil
+}
+
+// Served and removed images, which are still serving the users
+func (il *ImageList) allImages() []*Image {
+	il.lock.Lock()
+	defer il.lock.Unlock()
+	images := make([]*Image, 0, len(il.imagesByDockerID)+len(il.removedImages))
+	for _, img := range il.imagesByDockerID {
+		images = append(images, img)
+	}
+	for _, img := range il.removedImages {
+		images = append(images, img)
+	}
+	return images
+}
+
+func (il *ImageList) Snapshot(ctx context.Context) ([]ImageInfo, error) {
+	images := il.allImages()
+	infos := make([]ImageInfo, 0, len(images))
+	for _, img := range images {
+		info, err := img.Info(ctx)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		// the served one goes first
+		return !a.Removed && b.Removed
+	})
+	return infos, nil
+}
+
+// Image stops taking new users and isn't served again until it's changed
+// (rebuilt, retagged) or orca is restarted. Current users are served.
+func (il *ImageList) DrainImage(kind ImageKind, name string) error {
+	il.lock.Lock()
+	defer il.lock.Unlock()
+	img, found := il.imagesByKindAndName[kind][name]
+	if !found {
+		return ImageNotFoundErr
+	}
+	il.drainedImages[img.DockerID] = true
+	il.removeImage(img)
+	return nil
+}
+
+// Stops the container by its id (or unique prefix of it)
+func (il *ImageList) StopContainer(id string) error {
+	if id == "" {
+		return ContainerNotFoundErr
+	}
+	var match *Container
+	for _, img := range il.allImages() {
+		for _, oc := range img.listContainers() {
+			if !strings.HasPrefix(oc.DockerID, id) {
+				continue
+			}
+			if match != nil {
+				return errors.Errorf("container id %s is ambiguous", id)
+			}
+			match = oc
+		}
+	}
+	if match == nil {
+		return ContainerNotFoundErr
+	}
+	err := match.Stop()
+	if err == containerGoneErr {
+		return ContainerNotFoundErr
+	}
+	return err
+}
+
+// Ends the sessions of the user in all images, returns their number
+func (il *ImageList) KickUser(uid string) (kicked int) {
+	for _, img := range il.allImages() {
+		for _, cu := range img.listContainerUsers() {
+			if cu.user.ID == uid && cu.Kick() {
+				kicked++
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,112 @@
+package orca
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func testImage(kind ImageKind, name string, containerIDs ...string) *Image {
+	img := &Image{
+		Kind:                kind,
+		Name:                name,
+		DockerID:            "sha256:" + name,
+		containers:          make(map[string]*Container),
+		launching:           make(map[string]bool),
+		containerUsersByUID: make(map[string]*ContainerUser),
+		removedC:            make(chan struct{}),
+		retiredC:            make(chan struct{}),
+	}
+	for _, id := range containerIDs {
+		img.containers[id] = &Container{
+			DockerID: id,
+			Image:    img,
+			stopC:    make(chan struct{}, 1),
+			doneC:    make(chan struct{}),
+		}
+	}
+	return img
+}
+
+func testImageList(images ...*Image) *ImageList {
+	il := &ImageList{
+		imagesByKindAndName: make(map[ImageKind]map[string]*Image),
+		imagesByDockerID:    make(map[string]*Image),
+		removedImages:       make(map[string]*Image),
+		drainedImages:       make(map[string]bool),
+	}
+	for _, img := range images {
+		if il.imagesByKindAndName[img.Kind] == nil {
+			il.imagesByKindAndName[img.Kind] = make(map[string]*Image)
+		}
+		il.imagesByKindAndName[img.Kind][img.Name] = img
+		il.imagesByDockerID[img.DockerID] = img
+	}
+	return il
+}
+
+func TestAdminActions(t *testing.T) {
+	img := testImage(ImageKindSSH, "myorg/task", "abc1", "abc2", "def")
+	close(img.containers["def"].doneC)
+	// session that is already over
+	dead := &ContainerUser{user: &User{ID: "alice"}, abandonedC: make(chan struct{})}
+	close(dead.abandonedC)
+	img.containerUsersByUID["alice"] = dead
+	il := testImageList(img, testImage(ImageKindWeb, "site"))
+
+	if err := il.StopContainer("abc"); err == nil || err == ContainerNotFoundErr {
+		t.Errorf("ambiguous prefix: %v", err)
+	}
+	if err := il.StopContainer("abc1"); err != nil {
+		t.Errorf("stopping abc1: %s", err)
+	}
+	select {
+	case <-img.containers["abc1"].stopC:
+	default:
+		t.Error("abc1 wasn't asked to stop")
+	}
+	for _, id := range []string{"", "xyz", "def"} {
+		if err := il.StopContainer(id); err != ContainerNotFoundErr {
+			t.Errorf("stopping %q: %v", id, err)
+		}
+	}
+
+	if kicked := il.KickUser("alice"); kicked != 0 {
+		t.Errorf("kicked %d finished sessions", kicked)
+	}
+
+	if err := il.DrainImage(ImageKindSSH, "myorg/task"); err != nil {
+		t.Fatal(err)
+	}
+	if !img.IsRemoved() || !il.drainedImages[img.DockerID] {
+		t.Error("drained image is still served")
+	}
+	if err := il.DrainImage(ImageKindSSH, "myorg/task"); err != ImageNotFoundErr {
+		t.Errorf("draining twice: %v", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	img := testImage(ImageKindSSH, "task", "gone")
+	close(img.containers["gone"].doneC)
+	il := testImageList(img, testImage(ImageKindSSH, "another"))
+	il.removedImages["old"] = testImage(ImageKindSSH, "task")
+	close(il.removedImages["old"].removedC)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	infos, err := il.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 3 {
+		t.Fatalf("%d images", len(infos))
+	}
+	// by name, the served one before the removed one
+	if infos[0].Name != "another" || infos[1].Name != "task" || infos[1].Removed || !infos[2].Removed {
+		t.Errorf("wrong order: %+v", infos)
+	}
+	if len(infos[1].Containers) != 0 {
+		t.Errorf("gone container is listed: %+v", infos[1].Containers)
+	}
+}
@@ -0,0 +1,187 @@
+// Recording of the terminal sessions in the asciicast v2 format
+// (https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md),
+// playable with "asciinema play"
+package asciicast
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Event codes
+const (
+	EventOutput = "o"
+	EventInput  = "i"
+	EventResize = "r"
+)
+
+// Who and where was recorded, not a part of the format (players ignore it)
+type Metadata struct {
+	User      string `json:"user"`
+	Image     string `json:"image"`
+	Container string `json:"container"`
+}
+
+type header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	Orca      Metadata          `json:"orca"`
+}
+
+// Writes the events of one session. Recording errors never reach the session:
+// after the first one the recording stops and the error is returned by Close.
+type Recorder struct {
+	lock  sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	start time.Time
+	buf   []byte
+	err   error
+}
+
+// Creates the file (and the directories) readable only by the owner,
+// existing file is never overwritten
+func Create(path string, width, height int, term string, meta Metadata) (*Recorder, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := New(file, width, height, term, meta)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	rec.file = file
+	return rec, nil
+}
+
+// Recorder writing to w, w isn't closed by Close
+func New(w io.Writer, width, height int, term string, meta Metadata) (*Recorder, error) {
+	rec := &Recorder{
+		w:     bufio.NewWriter(w),
+		start: time.Now(),
+	}
+	hdr := header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: rec.start.Unix(),
+		Title:     meta.User + "@" + meta.Image,
+		Orca:      meta,
+	}
+	if term != "" {
+		hdr.Env = map[string]string{"TERM": term}
+	}
+	data, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = rec.w.Write(data)
+	err = rec.w.WriteByte('\n')
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (rec *Recorder) event(code string, data string) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if rec.err != nil {
+		return
+	}
+	rec.buf = append(rec.buf[:0], '[')
+	rec.buf = strconv.AppendFloat(rec.buf, time.Since(rec.start).Seconds(), 'f', 6, 64)
+	rec.buf = append(rec.buf, `,"`...)
+	rec.buf = append(rec.buf, code...)
+	rec.buf = append(rec.buf, `",`...)
+	// can't fail for a string, invalid utf-8 is replaced
+	encoded, _ := json.Marshal(data)
+	rec.buf = append(rec.buf, encoded...)
+	rec.buf = append(rec.buf, "]\n"...)
+	_, rec.err = rec.w.Write(rec.buf)
+}
+
+// Records the terminal size change
+func (rec *Recorder) Resize(width, height int) {
+	rec.event(EventResize, strconv.Itoa(width)+"x"+strconv.Itoa(height))
+}
+
+// Writer recording the data sent to the user
+func (rec *Recorder) Output() io.Writer {
+	return &streamWriter{rec: rec, code: EventOutput}
+}
+
+// Writer recording the data typed by the user
+func (rec *Recorder) Input() io.Writer {
+	return &streamWriter{rec: rec, code: EventInput}
+}
+
+// Flushes the recording, closes the file if it was created by Create
+func (rec *Recorder) Close() error {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if rec.err == nil {
+		rec.err = rec.w.Flush()
+	}
+	err := rec.err
+	if rec.file != nil {
+		if closeErr := rec.file.Close(); err == nil {
+			err = closeErr
+		}
+		rec.file = nil
+	}
+	// nothing is recorded after the close
+	if rec.err == nil {
+		rec.err = os.ErrClosed
+	}
+	return err
+}
+
+type streamWriter struct {
+	rec  *Recorder
+	code string
+	// incomplete utf-8 sequence at the end of the previous write
+	pending []byte
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	data := append(sw.pending, p...)
+	complete, rest := splitIncomplete(data)
+	if len(complete) != 0 {
+		sw.rec.event(sw.code, string(complete))
+	}
+	sw.pending = append(sw.pending[:0:0], rest...)
+	return len(p), nil
+}
+
+// Splits off the last rune if it is cut short
+func splitIncomplete(b []byte) (complete, rest []byte) {
+	for i := 1; i <= utf8.UTFMax && i <= len(b); i++ {
+		tail := b[len(b)-i:]
+		if !utf8.RuneStart(tail[0]) {
+			continue
+		}
+		if !utf8.FullRune(tail) {
+			return b[:len(b)-i], tail
+		}
+		break
+	}
+	return b, nil
+}
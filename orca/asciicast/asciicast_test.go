@@ -0,0 +1,109 @@
+package asciicast
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	var out bytes.Buffer
+	rec, err := New(&out, 80, 24, "xterm", Metadata{User: "alice", Image: "task", Container: "0123456789ab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	euro := []byte("€")
+	_, _ = rec.Output().Write([]byte("hello\r\n"))
+	_, _ = rec.Input().Write([]byte("ls\r"))
+	rec.Resize(100, 30)
+	output := rec.Output()
+	// the rune is split between the writes
+	_, _ = output.Write(euro[:1])
+	_, _ = output.Write(euro[1:])
+	err = rec.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	var hdr header
+	err = json.Unmarshal([]byte(lines[0]), &hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Version != 2 || hdr.Width != 80 || hdr.Height != 24 || hdr.Env["TERM"] != "xterm" || hdr.Orca.User != "alice" {
+		t.Errorf("bad header %s", lines[0])
+	}
+
+	expected := [][2]string{
+		{EventOutput, "hello\r\n"},
+		{EventInput, "ls\r"},
+		{EventResize, "100x30"},
+		{EventOutput, "€"},
+	}
+	var got [][2]string
+	for _, line := range lines[1:] {
+		var event []interface{}
+		err = json.Unmarshal([]byte(line), &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := event[0].(float64); !ok || len(event) != 3 {
+			t.Fatalf("bad event %s", line)
+		}
+		got = append(got, [2]string{event[1].(string), event[2].(string)})
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("events %q, expected %q", got, expected)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asciicast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	files := []struct {
+		name string
+		age  time.Duration
+	}{
+		{"task/new.cast", time.Minute},
+		{"task/older.cast", time.Hour},
+		{"other/oldest.cast", 2 * time.Hour},
+		{"other/ancient.cast", 48 * time.Hour},
+		{"other/notes.txt", 48 * time.Hour},
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		_ = os.MkdirAll(filepath.Dir(path), 0700)
+		err = ioutil.WriteFile(path, make([]byte, 10), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(path, now.Add(-file.age), now.Add(-file.age))
+	}
+
+	removed, err := Cleanup(dir, 24*time.Hour, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d, expected 2", removed)
+	}
+	for _, file := range files {
+		_, err := os.Stat(filepath.Join(dir, file.name))
+		kept := err == nil
+		if kept != (file.name != "other/oldest.cast" && file.name != "other/ancient.cast") {
+			t.Errorf("%s: kept=%v", file.name, kept)
+		}
+	}
+}
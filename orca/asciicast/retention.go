@@ -0,0 +1,55 @@
+package asciicast
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Removes the recordings (*.cast files under dir) older than maxAge, then
+// the oldest ones until the rest fit into maxSize bytes. Zero disables the limit.
+func Cleanup(dir string, maxAge time.Duration, maxSize int64) (removed int, err error) {
+	type recording struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var recordings []recording
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() && strings.HasSuffix(path, ".cast") {
+			recordings = append(recordings, recording{path, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	// newest first
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].modTime.After(recordings[j].modTime)
+	})
+
+	now := time.Now()
+	var total int64
+	for _, rec := range recordings {
+		total += rec.size
+		if (maxAge > 0 && now.Sub(rec.modTime) > maxAge) || (maxSize > 0 && total > maxSize) {
+			if rmErr := os.Remove(rec.path); rmErr != nil {
+				if err == nil {
+					err = rmErr
+				}
+				continue
+			}
+			removed++
+		}
+	}
+	return
+}
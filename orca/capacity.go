@@ -0,0 +1,178 @@
+package orca
+
+import (
+	"sync"
+
+	"github.com/Andrew-Morozko/orca/jobcontroller"
+	"github.com/pkg/errors"
+)
+
+// Fair (first come, first served) limit on the number of containers
+type limiter struct {
+	lock  sync.Mutex
+	max   int // -1 if unlimited
+	used  int
+	queue []*limiterTicket
+}
+
+type limiterTicket struct {
+	// closed when the slot is given to the ticket
+	grantedC chan struct{}
+	// latest position in the queue, starting from 1
+	positionC chan int
+}
+
+func newLimiter(max int) *limiter {
+	return &limiter{max: max}
+}
+
+// Limit of the number of containers of all images, -1 if unlimited
+var containerLimiter = newLimiter(-1)
+
+func SetMaxContainers(max int) {
+	containerLimiter.setMax(max)
+}
+
+var noCapacityErr = errors.New("no capacity for new containers")
+
+func (l *limiter) setMax(max int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.max = max
+	l.grant()
+}
+
+func (l *limiter) hasRoom() bool {
+	return l.max < 0 || l.used < l.max
+}
+
+// Takes the slot if it's availible and no one is waiting for it.
+// Forced acquire always succeeds, even over the limit.
+func (l *limiter) tryAcquire(force bool) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if force || (len(l.queue) == 0 && l.hasRoom()) {
+		l.used++
+		return true
+	}
+	return false
+}
+
+func (l *limiter) release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.used--
+	l.grant()
+}
+
+func (l *limiter) enqueue() *limiterTicket {
+	t := &limiterTicket{
+		grantedC:  make(chan struct{}),
+		positionC: make(chan int, 1),
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.queue = append(l.queue, t)
+	l.grant()
+	return t
+}
+
+// Leaves the queue. If the slot was already granted, it's released
+func (l *limiter) cancel(t *limiterTicket) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	select {
+	case <-t.grantedC:
+		l.used--
+	default:
+		for n, queued := range l.queue {
+			if queued == t {
+				l.queue = append(l.queue[:n], l.queue[n+1:]...)
+				break
+			}
+		}
+	}
+	l.grant()
+}
+
+// Must be called with the lock held
+func (l *limiter) grant() {
+	for len(l.queue) > 0 && l.hasRoom() {
+		l.used++
+		close(l.queue[0].grantedC)
+		l.queue[0] = nil
+		l.queue = l.queue[1:]
+	}
+	for n, t := range l.queue {
+		// only the latest position matters
+		select {
+		case <-t.positionC:
+		default:
+		}
+		t.positionC <- n + 1
+	}
+}
+
+var abandonedErr = errors.New("container is not needed anymore")
+
+// Waits for the slot in the queue, reporting position changes to positionC (if not nil).
+// Gives up if the image was removed or abandonedC is closed.
+func (l *limiter) acquire(jc jobcontroller.JobController, removedC, abandonedC <-chan struct{}, positionC chan<- int) (waited bool, err error) {
+	if l.tryAcquire(false) {
+		return false, nil
+	}
+	t := l.enqueue()
+	var position int
+	var positionDest chan<- int
+	for {
+		select {
+		case <-t.grantedC:
+			return true, nil
+		case position = <-t.positionC:
+			positionDest = positionC
+		case positionDest <- position:
+			positionDest = nil
+		case <-removedC:
+			l.cancel(t)
+			return true, ImageRemovedErr
+		case <-abandonedC:
+			l.cancel(t)
+			return true, abandonedErr
+		case <-jc.Done():
+			l.cancel(t)
+			return true, jc.Err()
+		}
+	}
+}
+
+// Reserves a slot for the new container of the image, waiting in the queue if needed.
+// abandonedC is closed if the container isn't needed anymore.
+func (oi *Image) reserveSlot(jc jobcontroller.JobController, abandonedC <-chan struct{}, positionC chan<- int) (waited bool, err error) {
+	// image limit first: waiting for it doesn't hold global slots
+	waitedImage, err := oi.limiter.acquire(jc, oi.removedC, abandonedC, positionC)
+	if err != nil {
+		return
+	}
+	waited, err = containerLimiter.acquire(jc, oi.removedC, abandonedC, positionC)
+	if err != nil {
+		oi.limiter.release()
+		return
+	}
+	return waited || waitedImage, nil
+}
+
+func (oi *Image) tryReserveSlot(force bool) bool {
+	if !oi.limiter.tryAcquire(force) {
+		return false
+	}
+	if !containerLimiter.tryAcquire(force) {
+		oi.limiter.release()
+		return false
+	}
+	return true
+}
+
+func (oi *Image) releaseSlot() {
+	containerLimiter.release()
+	oi.limiter.release()
+}
@@ -0,0 +1,48 @@
+package orca
+
+import "testing"
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1)
+	if !l.tryAcquire(false) {
+		t.Fatal("first acquire failed")
+	}
+	if l.tryAcquire(false) {
+		t.Fatal("acquired over the limit")
+	}
+
+	first := l.enqueue()
+	second := l.enqueue()
+	if pos := <-second.positionC; pos != 2 {
+		t.Errorf("second is #%d, expected #2", pos)
+	}
+
+	// cancelling the first moves the second up
+	l.cancel(first)
+	if pos := <-second.positionC; pos != 1 {
+		t.Errorf("second is #%d, expected #1", pos)
+	}
+
+	// pool must not jump the queue
+	if l.tryAcquire(false) {
+		t.Error("acquired while someone is queued")
+	}
+
+	l.release()
+	select {
+	case <-second.grantedC:
+	default:
+		t.Fatal("slot was not granted after release")
+	}
+	l.cancel(second)
+	if l.used != 0 {
+		t.Errorf("%d slots used after cancelling the granted ticket", l.used)
+	}
+
+	l.setMax(-1)
+	for i := 0; i < 10; i++ {
+		if !l.tryAcquire(false) {
+			t.Fatal("unlimited limiter refused")
+		}
+	}
+}
@@ -1,17 +1,25 @@
 package orca
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"net"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
+	"github.com/Andrew-Morozko/orca/config"
 	"github.com/Andrew-Morozko/orca/jobcontroller"
+	"github.com/Andrew-Morozko/orca/mylog"
+	"github.com/Andrew-Morozko/orca/orca/ioctrl"
+	"github.com/Andrew-Morozko/orca/orca/metrics"
+	"github.com/Andrew-Morozko/orca/orca/mydocker"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/pkg/errors"
 )
 
 type Container struct {
@@ -20,21 +28,55 @@ type Container struct {
 	// ui       *UserIdentity
 	URL *url.URL
 
+	startedAt time.Time
+
 	// Status string
 	// todo lock, so external observer can see
 	concurrentUsers int
 	totalUsers      int
 	reservedUsers   int
+	// launched for the pool, already counted as warm
+	warm bool
+
+	// managed network orca reaches the container by, removed with the container if owned
+	network     string
+	ownsNetwork bool
 	// userActivity    chan *User // + time?
 	users             map[string]*User
 	userLeft          chan *User
 	candidacyResponce chan *User
+
+	// admin requests
+	infoC chan chan ContainerInfo
+	stopC chan struct{}
+	// closed when the lifecycle is over
+	doneC chan struct{}
 }
 
-func (oi *Image) launchContainer(jc jobcontroller.JobController) (oc *Container, err error) {
+// Pooled containers are launched idle, otherwise the caller reserves the container.
+// Caller has to reserve the slot, on success it's owned by the container
+func (oi *Image) launchContainer(jc jobcontroller.JobController, pooled bool) (oc *Container, err error) {
 	jc.Job.Add(1)
 	defer jc.Job.Done()
 	jc.Logger.Log("Creating a container of ", oi.Name)
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			metrics.ContainersFailed.WithLabelValues(oi.Name).Inc()
+		} else {
+			metrics.ContainersLaunched.WithLabelValues(oi.Name).Inc()
+			metrics.LaunchDuration.WithLabelValues(oi.Name).Observe(metrics.Since(start))
+		}
+	}()
+	err = oi.changeContainerCount(1)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = oi.changeContainerCount(-1)
+		}
+	}()
 	// if needs extra non default config - put here
 	contConf := oi.containerConfig
 
@@ -51,12 +93,49 @@ func (oi *Image) launchContainer(jc jobcontroller.JobController) (oc *Container,
 		// Derive from host or send in X- header?
 
 		newEnv[len(newEnv)-1] = fmt.Sprintf(
-			"ORCA_INTERNAL_CONTAINER_URL="+os.Getenv("ORCA_HTTP_CONTAINER_URL_FORMAT"),
+			"ORCA_INTERNAL_CONTAINER_URL="+config.Get().HTTP.ContainerURLFormat,
 			strings.ToLower(oi.Name),
 		)
 		contConf.Env = newEnv
 	}
-	res, err := Docker.ContainerCreate(jc, contConf, oi.hostConfig, oi.networkingConfig, "")
+	// the container is known by this label until it's registered
+	launchID := randomHex(8)
+	oi.setLaunching(launchID, true)
+	defer oi.setLaunching(launchID, false)
+	contCfgCopy := *contConf
+	contCfgCopy.Labels = make(map[string]string, len(contConf.Labels)+1)
+	for k, v := range contConf.Labels {
+		contCfgCopy.Labels[k] = v
+	}
+	contCfgCopy.Labels["orca.internal.launch"] = launchID
+	contConf = &contCfgCopy
+
+	hostConfig := oi.hostConfig
+	networkingConfig := oi.networkingConfig
+	networkName, ownsNetwork, err := oi.containerNetwork(jc)
+	if err != nil {
+		return nil, err
+	}
+	if ownsNetwork {
+		defer func() {
+			if err != nil {
+				err2 := removeNetwork(jc.CleanupCtx, networkName)
+				jc.Logger.Err(err2, "failed to remove network of the failed container")
+			}
+		}()
+	}
+	if networkName != "" {
+		hostConfigCopy := *hostConfig
+		hostConfigCopy.NetworkMode = container.NetworkMode(networkName)
+		hostConfig = &hostConfigCopy
+		networkingConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				networkName: {},
+			},
+		}
+	}
+
+	res, err := Docker.ContainerCreate(jc, contConf, hostConfig, networkingConfig, "")
 	if err != nil {
 		return nil, err
 	}
@@ -68,11 +147,18 @@ func (oi *Image) launchContainer(jc jobcontroller.JobController) (oc *Container,
 		}
 	}()
 
+	for _, name := range oi.network.attach {
+		err = Docker.NetworkConnect(jc, name, dockerId, nil)
+		if err != nil {
+			return nil, errors.WithMessage(err, "attaching network "+name)
+		}
+	}
+
 	if len(res.Warnings) == 0 {
 		jc.Logger.Logf("Container of %s created", oi.Name)
 	} else {
 		jc.Logger.Warn.Logf("Container of %s created. Warnings:", oi.Name)
-		for warn := range res.Warnings {
+		for _, warn := range res.Warnings {
 			jc.Logger.Warn.Log(warn)
 		}
 	}
@@ -82,42 +168,147 @@ func (oi *Image) launchContainer(jc jobcontroller.JobController) (oc *Container,
 		return nil, err
 	}
 
+	opts := containerOptions{
+		warm:        pooled,
+		network:     networkName,
+		ownsNetwork: ownsNetwork,
+	}
+	if !pooled {
+		// assume that whatever requested our creation reserved us
+		// (as if we sent candidacy)
+		opts.reservedUsers = 1
+	}
+	return oi.newContainer(jc, dockerId, opts)
+}
+
+type containerOptions struct {
+	// number of users that are already assigned to the container
+	reservedUsers int
+	// container is in the pool
+	warm bool
+	// container is reached over this network, if set
+	network     string
+	ownsNetwork bool
+}
+
+// Takes over the running docker container
+func (oi *Image) newContainer(jc jobcontroller.JobController, dockerId string, opts containerOptions) (oc *Container, err error) {
 	oc = &Container{
 		DockerID: dockerId,
 		Image:    oi,
 
+		reservedUsers: opts.reservedUsers,
+		warm:          opts.warm,
+		network:       opts.network,
+		ownsNetwork:   opts.ownsNetwork,
+
+		startedAt: time.Now(),
+
 		users:             make(map[string]*User),
 		userLeft:          make(chan *User),
 		candidacyResponce: make(chan *User),
+
+		infoC: make(chan chan ContainerInfo),
+		stopC: make(chan struct{}, 1),
+		doneC: make(chan struct{}),
 	}
-	jc = jc.AddLoggerPrefix(oc.String())
+	jc = jc.AddLoggerField(mylog.FieldImage, oi.Name).AddLoggerField(mylog.FieldContainer, mydocker.ShortID(dockerId))
 
 	// Post-config
-	switch oi.Kind {
-	case ImageKindWeb:
+	if oi.Port != 0 {
 		// inspect, get ip of the container and the port
 		res, err := Docker.ContainerInspect(jc, oc.DockerID)
 		if err != nil {
 			return nil, err
 		}
+		ip := res.NetworkSettings.IPAddress
+		if settings, found := res.NetworkSettings.Networks[oc.network]; found {
+			ip = settings.IPAddress
+		} else if ip == "" {
+			// adopted container on some managed network
+			for _, settings := range res.NetworkSettings.Networks {
+				ip = settings.IPAddress
+				break
+			}
+		}
 		oc.URL = &url.URL{
-			Scheme: "http", // todo: configuarable?
-			Host:   fmt.Sprintf("%s:%d", res.NetworkSettings.IPAddress, oc.Image.Port),
-			Path:   "/",
+			Host: fmt.Sprintf("%s:%d", ip, oc.Image.Port),
 			// HACK FOR TESTING
 			// Host:   fmt.Sprintf("%s:%d", "<tgt-ip>", 8090),
 		}
+		switch oi.Kind {
+		case ImageKindWeb:
+			oc.URL.Scheme = "http" // todo: configuarable?
+			oc.URL.Path = "/"
+		default:
+			oc.URL.Scheme = "tcp"
+		}
 	}
 
-	// assume that whatever requested our creation reserved us
-	// (as if we sent candidacy)
-	oc.reservedUsers = 1
+	oi.registerContainer(oc)
 
 	jc.Job.Add(1)
 	go oc.manageContainerState(jc)
 
 	return oc, nil
 }
+
+// Containers left over from the previous run could be reused only if
+// nothing in them is tied to particular users
+func (oi *Image) canAdoptContainers() bool {
+	return oi.TotalUsers < 0 && oi.ConnectionMethod != ConnectionMethodAttach
+}
+
+// Takes over the running container left over from the previous run
+func (oi *Image) adoptContainer(jc jobcontroller.JobController, dockerId string) (oc *Container, err error) {
+	err = oi.changeContainerCount(1)
+	if err != nil {
+		return nil, err
+	}
+	// already running, so counted even over the limit
+	oi.tryReserveSlot(true)
+	var opts containerOptions
+	opts.network, opts.ownsNetwork, err = oi.adoptNetwork(jc, dockerId)
+	if err == nil {
+		oc, err = oi.newContainer(jc, dockerId, opts)
+	}
+	if err != nil {
+		oi.releaseSlot()
+		_ = oi.changeContainerCount(-1)
+	}
+	return
+}
+
+func (oi *Image) registerContainer(oc *Container) {
+	oi.containersLock.Lock()
+	oi.containers[oc.DockerID] = oc
+	oi.containersLock.Unlock()
+}
+
+func (oi *Image) unregisterContainer(oc *Container) {
+	oi.containersLock.Lock()
+	delete(oi.containers, oc.DockerID)
+	oi.containersLock.Unlock()
+}
+
+func (oi *Image) setLaunching(launchID string, launching bool) {
+	oi.containersLock.Lock()
+	defer oi.containersLock.Unlock()
+	if launching {
+		oi.launching[launchID] = true
+	} else {
+		delete(oi.launching, launchID)
+	}
+}
+
+// Registered or being launched
+func (oi *Image) hasContainer(dockerId, launchID string) bool {
+	oi.containersLock.Lock()
+	defer oi.containersLock.Unlock()
+	_, found := oi.containers[dockerId]
+	return found || (launchID != "" && oi.launching[launchID])
+}
+
 func (oc *Container) String() string {
 	return fmt.Sprintf(`Container{DockerID=%s}`, oc.DockerID[:8])
 }
@@ -127,11 +318,24 @@ type contatinerCandidate struct {
 	concurrentUsers int
 	totalUsers      int
 	// other info to allow user sheduler to make a decidion
+	startedAt time.Time
+	// users the container could serve before the end of life, -1 if unlimited
+	remainingUsers int
+	// percents of a single cpu, sampled only if scheduler needs it
+	cpuUsage float64
+}
+
+func (oc *Container) remainingUsers() int {
+	if oc.Image.TotalUsers < 0 {
+		return -1
+	}
+	return oc.Image.TotalUsers - oc.totalUsers
 }
 
 func (oc *Container) manageContainerState(jc jobcontroller.JobController) {
 	// Manages lifecycle of the container
 	defer jc.Job.Done()
+	defer close(oc.doneC)
 
 	defer func() {
 		jc.Logger.Debug.Log("lifecycle is over, removing")
@@ -139,44 +343,90 @@ func (oc *Container) manageContainerState(jc jobcontroller.JobController) {
 		err := Docker.ContainerRemove(jc.CleanupCtx, oc.DockerID,
 			types.ContainerRemoveOptions{Force: true})
 		jc.Logger.Err(err, "Can't remove")
+		if oc.ownsNetwork {
+			err = removeNetwork(jc.CleanupCtx, oc.network)
+			jc.Logger.Err(err, "Can't remove the network")
+		}
+		// users that didn't leave before the container was gone
+		metrics.ConcurrentUsers.WithLabelValues(oc.Image.Name).Sub(float64(oc.concurrentUsers))
+		oc.Image.unregisterContainer(oc)
+		oc.Image.releaseSlot()
+		_ = oc.Image.changeContainerCount(-1)
+		metrics.ContainersRemoved.WithLabelValues(oc.Image.Name).Inc()
 	}()
 
 	jc.Logger.Debug.Log("Entering lifecycle mangagenet")
 
-	deletionTime := 30 * time.Second // w/o users
+	deletionTime := config.Get().Containers.DeletionTime // w/o users
 	var deletionTimer *time.Timer
 	var deletionTimerC <-chan time.Time
+	stopDeletionTimer := func() {
+		if deletionTimer != nil {
+			if !deletionTimer.Stop() {
+				<-deletionTimer.C
+			}
+			deletionTimer = nil
+			deletionTimerC = nil
+		}
+	}
 	isEndOfLife := false
 
+	// warm containers are kept idle in the pool w/o deletion timer
+	warm := oc.warm
+	askedWarm := warm
+	defer func() {
+		if warm {
+			oc.Image.releaseWarm()
+		}
+	}()
+	shutdownRequestedC := jc.ShutdownRequested()
+	stopC := oc.stopC
+
 	candidate := contatinerCandidate{
-		container: oc,
+		container:      oc,
+		startedAt:      oc.startedAt,
+		remainingUsers: oc.remainingUsers(),
+	}
+
+	var cpuUsageC chan float64
+	if oc.Image.scheduler.NeedsCPUStats() {
+		ctx, cancel := context.WithCancel(jc)
+		defer cancel()
+		cpuUsageC = make(chan float64)
+		go oc.sampleCPU(ctx, cpuUsageC)
 	}
 
 	electionsStartSignalC := oc.Image.getElectionStartSignalC
+	imageRemovedC := oc.Image.removedC
 	var electionStartSignal chan struct{}
 	var candidatesChannel chan contatinerCandidate
 	var getCandidatesChan chan chan contatinerCandidate
 
 	for {
 		isEndOfLife = isEndOfLife || oc.totalUsers == oc.Image.TotalUsers
+		if warm && (oc.concurrentUsers != 0 || isEndOfLife) {
+			// taken out of the pool
+			warm = false
+			oc.Image.releaseWarm()
+		}
 		if oc.concurrentUsers == 0 && oc.reservedUsers == 0 {
 			if isEndOfLife || jc.IsShuttingDown() {
 				return
-			} else {
-				if deletionTimer == nil {
-					deletionTimer = time.NewTimer(deletionTime)
-					deletionTimerC = deletionTimer.C
-				}
+			}
+			if !askedWarm {
+				askedWarm = true
+				warm = oc.Image.requestWarm()
+			}
+			if warm {
+				stopDeletionTimer()
+			} else if deletionTimer == nil {
+				deletionTimer = time.NewTimer(deletionTime)
+				deletionTimerC = deletionTimer.C
 			}
 		} else {
 			if oc.concurrentUsers != 0 {
-				if deletionTimer != nil {
-					if !deletionTimer.Stop() {
-						<-deletionTimer.C
-					}
-					deletionTimer = nil
-					deletionTimerC = nil
-				}
+				askedWarm = false
+				stopDeletionTimer()
 			}
 		}
 
@@ -214,8 +464,10 @@ func (oc *Container) manageContainerState(jc jobcontroller.JobController) {
 				// We were accepted, bind the user to us
 				oc.concurrentUsers++
 				oc.totalUsers++
+				metrics.ConcurrentUsers.WithLabelValues(oc.Image.Name).Inc()
 				candidate.concurrentUsers = oc.concurrentUsers
 				candidate.totalUsers = oc.totalUsers
+				candidate.remainingUsers = oc.remainingUsers()
 
 				// now we have a ui... Just adding it to list for future command panel purpopses
 				oc.users[ui.ID] = ui
@@ -224,29 +476,177 @@ func (oc *Container) manageContainerState(jc jobcontroller.JobController) {
 		case ui := <-oc.userLeft:
 			jc.Logger.Debug.Log("User has left")
 			oc.concurrentUsers--
+			metrics.ConcurrentUsers.WithLabelValues(oc.Image.Name).Dec()
+			candidate.concurrentUsers = oc.concurrentUsers
 			delete(oc.users, ui.ID)
 
+		case candidate.cpuUsage = <-cpuUsageC:
+
 		case <-jc.Done():
 			jc.Logger.Debug.Log("Context done")
 			return
 
 		case <-deletionTimerC:
 			jc.Logger.Debug.Log("Deletion timer kicked off")
+			deletionTimer = nil
+			deletionTimerC = nil
+			isEndOfLife = true
+
+		case <-shutdownRequestedC:
+			// idle warm containers have no deletion timer to wake us up
+			shutdownRequestedC = nil
+
+		case reply := <-oc.infoC:
+			info := ContainerInfo{
+				DockerID:        oc.DockerID,
+				StartedAt:       oc.startedAt,
+				ConcurrentUsers: oc.concurrentUsers,
+				TotalUsers:      oc.totalUsers,
+				ReservedUsers:   oc.reservedUsers,
+				Warm:            warm,
+				EndOfLife:       isEndOfLife,
+			}
+			for uid := range oc.users {
+				info.Users = append(info.Users, uid)
+			}
+			reply <- info
+
+		case <-stopC:
+			// users are notified by the container exit,
+			// we're removed once all of them are gone
+			jc.Logger.Log("Stop requested")
+			stopC = nil
+			isEndOfLife = true
+			jc.Job.Add(1)
+			go func() {
+				defer jc.Job.Done()
+				err := Docker.ContainerStop(jc, oc.DockerID, &containerStopTimeout)
+				jc.Logger.Err(err, "failed to stop")
+			}()
+
+		case <-imageRemovedC:
+			// Serve the current users, but don't take new ones
+			jc.Logger.Debug.Log("Image was removed")
+			imageRemovedC = nil
 			isEndOfLife = true
 		}
 	}
 }
 
-func (oc *Container) GetStream(ctx context.Context) (types.HijackedResponse, error) {
-	stream, err := Docker.ContainerAttach(ctx, oc.DockerID, types.ContainerAttachOptions{
-		Stream:     true,
-		Stdin:      true,
-		Stdout:     true,
-		Stderr:     true,
-		DetachKeys: "",
-	})
+// Options of the user's process, only used by the exec connection method
+type StreamOptions struct {
+	Tty bool
+	Env []string
+}
+
+// User's connection to the container
+type Stream struct {
+	types.HijackedResponse
+	container *Container
+	// set if the stream is connected to the process started by "docker exec"
+	execID string
+	// set if the connect method uses telnet
+	telnet *ioctrl.TelnetConn
+	// without a tty docker multiplexes stdout and stderr, see Multiplexed
+	multiplexed bool
+}
+
+var connectTimeout = 10 * time.Second
+
+func (oc *Container) GetStream(ctx context.Context, opts StreamOptions) (*Stream, error) {
+	st := &Stream{
+		container: oc,
+	}
+	var err error
+	switch oc.Image.ConnectionMethod {
+	case ConnectionMethodExec:
+		var res types.IDResponse
+		res, err = Docker.ContainerExecCreate(ctx, oc.DockerID, types.ExecConfig{
+			Tty:          opts.Tty,
+			AttachStdin:  true,
+			AttachStdout: true,
+			AttachStderr: true,
+			Env:          opts.Env,
+			Cmd:          oc.Image.Command,
+		})
+		if err != nil {
+			return nil, err
+		}
+		st.execID = res.ID
+		st.multiplexed = !opts.Tty
+		st.HijackedResponse, err = Docker.ContainerExecAttach(ctx, st.execID, types.ExecStartCheck{
+			Tty: opts.Tty,
+		})
+	case ConnectionMethodConnect:
+		dialCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+		var conn net.Conn
+		conn, err = oc.Dial(dialCtx)
+		cancel()
+		if err != nil {
+			return nil, errors.WithMessage(err, "connecting to the container")
+		}
+		if oc.Image.ResizeMethod == ResizeMethodTelnet {
+			st.telnet = ioctrl.NewTelnetConn(conn)
+			conn = st.telnet
+		}
+		st.HijackedResponse = types.HijackedResponse{
+			Conn:   conn,
+			Reader: bufio.NewReader(conn),
+		}
+	default:
+		st.multiplexed = !oc.Image.containerConfig.Tty
+		st.HijackedResponse, err = Docker.ContainerAttach(ctx, oc.DockerID, types.ContainerAttachOptions{
+			Stream:     true,
+			Stdin:      true,
+			Stdout:     true,
+			Stderr:     true,
+			DetachKeys: "",
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Output of the stream has to be split with stdcopy.StdCopy
+func (st *Stream) Multiplexed() bool {
+	return st.multiplexed
+}
+
+func (st *Stream) Resize(ctx context.Context, height, width int) error {
+	switch {
+	case st.execID != "":
+		return Docker.ContainerExecResize(ctx, st.execID, types.ResizeOptions{
+			Height: uint(height),
+			Width:  uint(width),
+		})
+	case st.telnet != nil:
+		return st.telnet.SetWindowSize(width, height)
+	case st.container.Image.ConnectionMethod == ConnectionMethodConnect:
+		// no way to pass the size
+		return nil
+	default:
+		return st.container.ResizeTTY(ctx, height, width)
+	}
+}
+
+var ErrStillRunning = errors.New("process is still running")
 
-	return stream, err
+// Exit code of the exec'd process. For other streams the exit code
+// is reported by the ContainerUser.
+func (st *Stream) ExitCode(ctx context.Context) (int, error) {
+	if st.execID == "" {
+		return 0, errors.New("stream is not an exec")
+	}
+	res, err := Docker.ContainerExecInspect(ctx, st.execID)
+	if err != nil {
+		return 0, err
+	}
+	if res.Running {
+		return 0, ErrStillRunning
+	}
+	return res.ExitCode, nil
 }
 
 func (oc *Container) ResizeTTY(ctx context.Context, height, width int) error {
@@ -260,3 +660,22 @@ func (oc *Container) WaitForShutdown(ctx context.Context) (<-chan container.Cont
 	return Docker.ContainerWait(ctx, oc.DockerID, container.WaitConditionNotRunning)
 
 }
+
+var dialRetryDelay = 200 * time.Millisecond
+
+// Connects to the tcp port of the container. Retries until ctx is done,
+// since the service inside of a freshly started container may not be listening yet.
+func (oc *Container) Dial(ctx context.Context) (conn net.Conn, err error) {
+	var d net.Dialer
+	for {
+		conn, err = d.DialContext(ctx, "tcp", oc.URL.Host)
+		if err == nil {
+			return
+		}
+		select {
+		case <-time.After(dialRetryDelay):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package orca
 
 import (
+	"context"
 	"github.com/Andrew-Morozko/orca/jobcontroller"
+	"github.com/Andrew-Morozko/orca/orca/metrics"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
@@ -35,6 +38,16 @@ type ContainerUser struct {
 	containerC         chan *Container
 	containerAliveC    chan struct{}
 	containerShutdownC chan ContainerStatus
+	// position in the queue for the container
+	queue *queueState
+	// someone stopped waiting in the queue
+	queueLeftC chan struct{}
+	// closed on exit, so pending container request is cancelled
+	abandonedC chan struct{}
+
+	// admin requests
+	infoC chan chan ContainerUserInfo
+	kickC chan chan bool
 }
 
 type ContainerState uint8
@@ -93,8 +106,44 @@ func (cs ContainerStatus) String() string {
 	return str
 }
 
+// Latest position in the queue, shared by everyone waiting for the container
+type queueState struct {
+	lock     sync.Mutex
+	position int
+	// closed (and replaced) on every change
+	changedC chan struct{}
+	waiting  int
+}
+
+func newQueueState() *queueState {
+	return &queueState{changedC: make(chan struct{})}
+}
+
+func (qs *queueState) set(position int) {
+	qs.lock.Lock()
+	defer qs.lock.Unlock()
+	qs.position = position
+	close(qs.changedC)
+	qs.changedC = make(chan struct{})
+}
+
+// Position (0 if not queued) and the chan closed on the next change
+func (qs *queueState) get() (int, <-chan struct{}) {
+	qs.lock.Lock()
+	defer qs.lock.Unlock()
+	return qs.position, qs.changedC
+}
+
+func (qs *queueState) addWaiting(delta int) (waiting int) {
+	qs.lock.Lock()
+	defer qs.lock.Unlock()
+	qs.waiting += delta
+	return qs.waiting
+}
+
 var InactivityTimeoutErr = errors.New("Inactivity Timeout Expired")
 var SessionTimeoutErr = errors.New("Total Timeout Expired")
+var KickedErr = errors.New("Kicked by the administrator")
 
 func (cu *ContainerUser) String() string {
 	return fmt.Sprintf("ContainerUser{container=%s, user=%s, image=%s}", cu.container, cu.user, cu.image)
@@ -103,6 +152,14 @@ func (cu *ContainerUser) manageContainerUserState(jc jobcontroller.JobController
 
 	defer jc.Job.Done()
 
+	sessionStart := time.Now()
+	metrics.Sessions.WithLabelValues(cu.image.Kind, cu.image.Name).Inc()
+	metrics.SessionsActive.WithLabelValues(cu.image.Kind, cu.image.Name).Inc()
+	defer func() {
+		metrics.SessionsActive.WithLabelValues(cu.image.Kind, cu.image.Name).Dec()
+		metrics.SessionDuration.WithLabelValues(cu.image.Kind).Observe(metrics.Since(sessionStart))
+	}()
+
 	defer func() {
 		close(cu.statusC)
 		close(cu.containerC)
@@ -111,6 +168,7 @@ func (cu *ContainerUser) manageContainerUserState(jc jobcontroller.JobController
 		close(cu.noMoreConnectionsNotification)
 		close(cu.activityNotification)
 		close(cu.connectionDroppedNotification)
+		close(cu.abandonedC)
 	}()
 
 	var containerDest chan *Container
@@ -122,12 +180,19 @@ func (cu *ContainerUser) manageContainerUserState(jc jobcontroller.JobController
 
 	noMoreConnections := !cu.image.PersistBetweenReconnects
 
+	queuePositionSourceC := make(chan int)
+
 	lastState := ContainerStateDead
-	containerSourceC, containerSourceErrC := cu.image.getContainerC(jc, cu.user)
+	containerSourceC, containerSourceErrC := cu.image.getContainerC(jc, cu.user, cu.abandonedC, queuePositionSourceC)
 	cu.status.ContainerState = ContainerStateStarting
 
 	sessionTimer := time.NewTimer(cu.image.Timeouts.Total)
+	// paused while starting, waiting in the queue isn't inactivity
 	inactiveTimer := time.NewTimer(cu.image.Timeouts.Inactive)
+	inactiveTimer.Stop()
+	// for the admin, timers don't tell when they fire
+	sessionDeadline := time.Now().Add(cu.image.Timeouts.Total)
+	inactiveDeadline := time.Now().Add(cu.image.Timeouts.Inactive)
 
 	for {
 		if lastState != cu.status.ContainerState {
@@ -136,6 +201,8 @@ func (cu *ContainerUser) manageContainerUserState(jc jobcontroller.JobController
 				// container has started, those channels are useless now
 				containerSourceC = nil
 				containerSourceErrC = nil
+				queuePositionSourceC = nil
+				cu.queue.set(0)
 			}
 
 			switch cu.status.ContainerState {
@@ -154,17 +221,20 @@ func (cu *ContainerUser) manageContainerUserState(jc jobcontroller.JobController
 			// send only working containers
 			if cu.status.ContainerState == ContainerStateWorking {
 				containerDest = cu.containerC
+				inactiveTimer.Reset(cu.image.Timeouts.Inactive)
+				inactiveDeadline = time.Now().Add(cu.image.Timeouts.Inactive)
 				execResultSourceC, execErrorSourceC = cu.container.WaitForShutdown(jc)
 				// notify container about new user
 				select {
 				case cu.container.candidacyResponce <- cu.user:
 				case <-jc.Done():
 				}
+				// on every exit, the container keeps counting users after jc is done
+				oc := cu.container
 				defer func() {
 					select {
-					case cu.container.userLeft <- cu.user:
-					case <-jc.Done():
-						return
+					case oc.userLeft <- cu.user:
+					case <-oc.doneC:
 					}
 				}()
 
@@ -190,6 +260,15 @@ func (cu *ContainerUser) manageContainerUserState(jc jobcontroller.JobController
 				}
 			}
 
+		case position := <-queuePositionSourceC:
+			cu.queue.set(position)
+		case <-cu.queueLeftC:
+			if cu.status.ContainerState == ContainerStateStarting && cu.queue.addWaiting(0) == 0 {
+				jc.Logger.Log("Everyone left the queue")
+				cu.status.ContainerState = ContainerStateShutdownWithErr
+				cu.status.Err = abandonedErr
+			}
+
 		case containerAliveDest <- struct{}{}:
 		case containerShutdownDest <- cu.status:
 			jc.Logger.Debug.Log("containerShutdownDest <- cu.status:")
@@ -222,11 +301,43 @@ func (cu *ContainerUser) manageContainerUserState(jc jobcontroller.JobController
 			}
 
 		case cu.activityNotification <- struct{}{}:
-			if !inactiveTimer.Stop() {
-				<-inactiveTimer.C
+			// otherwise the timer is paused or has fired already
+			if cu.status.ContainerState == ContainerStateWorking {
+				if !inactiveTimer.Stop() {
+					<-inactiveTimer.C
+				}
+				jc.Logger.Debug.Log("Reset timeout for ", cu)
+				inactiveTimer.Reset(cu.image.Timeouts.Inactive)
+				inactiveDeadline = time.Now().Add(cu.image.Timeouts.Inactive)
+			}
+
+		case reply := <-cu.infoC:
+			info := ContainerUserInfo{
+				User:         cu.user.ID,
+				Status:       cu.status,
+				Connections:  cu.connectionCount,
+				SessionLeft:  time.Until(sessionDeadline),
+				InactiveLeft: time.Until(inactiveDeadline),
+			}
+			if cu.status.ContainerState == ContainerStateStarting {
+				info.QueuePosition, _ = cu.queue.get()
+				info.InactiveLeft = cu.image.Timeouts.Inactive
+			}
+			if cu.container != nil {
+				info.ContainerID = cu.container.DockerID
+			}
+			reply <- info
+
+		case reply := <-cu.kickC:
+			switch cu.status.ContainerState {
+			case ContainerStateStarting, ContainerStateWorking:
+				jc.Logger.Log("Kicked by the administrator")
+				cu.status.ContainerState = ContainerStateShutdownWithErr
+				cu.status.Err = KickedErr
+				reply <- true
+			default:
+				reply <- false
 			}
-			jc.Logger.Debug.Log("Reset timeout for ", cu)
-			inactiveTimer.Reset(cu.image.Timeouts.Inactive)
 
 		case cu.noMoreConnectionsNotification <- struct{}{}:
 			// no new connections, guaranteed.
@@ -289,20 +400,63 @@ func (cu *ContainerUser) ShutdownDone() <-chan ContainerStatus {
 	return cu.containerShutdownC
 }
 
+var QueuedErr = errors.New("waiting in the queue for the container")
+
 // returns running container, blocks while container starting
 func (cu *ContainerUser) GetContainer() (oc *Container, status ContainerStatus) {
-	select {
-	case oc = <-cu.containerC:
-		status = <-cu.statusC
-		if status.ContainerState != ContainerStateWorking {
-			// concurrency is hard lol
-			cu.NotifyConnectionClosed()
+	return cu.GetContainerQueued(context.Background(), nil)
+}
+
+// Like GetContainer, but reports the position in the queue if the container limit is reached.
+// If queued returns false, stops waiting (the place in the queue is kept while
+// the ContainerUser is alive) and returns QueuedErr.
+// If ctx is done first, returns its error; the place in the queue is given up
+// unless someone else is still waiting for this container.
+func (cu *ContainerUser) GetContainerQueued(ctx context.Context, queued func(position int) bool) (oc *Container, status ContainerStatus) {
+	var changedC <-chan struct{}
+	reported := 0
+	if queued != nil {
+		cu.queue.addWaiting(1)
+		defer func() {
+			if cu.queue.addWaiting(-1) == 0 && ctx.Err() != nil {
+				select {
+				case cu.queueLeftC <- struct{}{}:
+				case <-cu.abandonedC:
+				}
+			}
+		}()
+	}
+	for {
+		if queued != nil {
+			var position int
+			position, changedC = cu.queue.get()
+			// everyone gets the current position, then only the changes
+			if position != 0 && position != reported {
+				reported = position
+				if !queued(position) {
+					status.ContainerState = ContainerStateStarting
+					status.Err = QueuedErr
+					return nil, status
+				}
+			}
+		}
+		select {
+		case oc = <-cu.containerC:
+			status = <-cu.statusC
+			if status.ContainerState != ContainerStateWorking {
+				// concurrency is hard lol
+				cu.NotifyConnectionClosed()
+				return nil, status
+			} else {
+				return oc, status
+			}
+		case status = <-cu.containerShutdownC:
+			return nil, status
+		case <-changedC:
+		case <-ctx.Done():
+			status.ContainerState = ContainerStateStarting
+			status.Err = ctx.Err()
 			return nil, status
-		} else {
-			return oc, status
 		}
-	case status = <-cu.containerShutdownC:
-		return nil, status
 	}
-
 }
@@ -0,0 +1,49 @@
+package orca
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGetContainerQueued(t *testing.T) {
+	cu := &ContainerUser{
+		statusC:            make(chan ContainerStatus),
+		containerC:         make(chan *Container),
+		containerShutdownC: make(chan ContainerStatus),
+		queue:              newQueueState(),
+		queueLeftC:         make(chan struct{}),
+		abandonedC:         make(chan struct{}),
+	}
+	cu.queue.set(3)
+
+	// the queue hasn't moved, every caller still gets the position
+	for i := 0; i < 2; i++ {
+		got := 0
+		_, status := cu.GetContainerQueued(context.Background(), func(position int) bool {
+			got = position
+			return false
+		})
+		if status.Err != QueuedErr {
+			t.Fatalf("caller %d got %s, expected QueuedErr", i+1, status)
+		}
+		if got != 3 {
+			t.Errorf("caller %d got position %d, expected 3", i+1, got)
+		}
+	}
+
+	// the last one to go away gives up the place
+	leftC := make(chan struct{})
+	go func() {
+		<-cu.queueLeftC
+		close(leftC)
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	_, status := cu.GetContainerQueued(ctx, func(position int) bool {
+		cancel()
+		return true
+	})
+	if status.Err != context.Canceled {
+		t.Errorf("got %s after the ctx was cancelled", status)
+	}
+	<-leftC
+}
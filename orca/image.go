@@ -2,20 +2,42 @@ package orca
 
 import (
 	"fmt"
+	"net"
 
+	"github.com/Andrew-Morozko/orca/config"
 	"github.com/Andrew-Morozko/orca/jobcontroller"
+	"github.com/Andrew-Morozko/orca/mylog"
+	"github.com/Andrew-Morozko/orca/orca/metrics"
 	"github.com/Andrew-Morozko/orca/orca/mydocker"
 
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/anmitsu/go-shlex"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/go-connections/nat"
 	"github.com/pkg/errors"
 )
 
+type ConnectionMethod = string
+
+const (
+	ConnectionMethodAttach  ConnectionMethod = "attach"
+	ConnectionMethodConnect ConnectionMethod = "connect"
+	ConnectionMethodExec    ConnectionMethod = "exec"
+)
+
+// How the terminal size is passed to the container by the connect method
+type ResizeMethod = string
+
+const (
+	ResizeMethodNone ResizeMethod = "none"
+	// telnet NAWS option, data is sent using the telnet protocol
+	ResizeMethodTelnet ResizeMethod = "telnet"
+)
+
 type Image struct {
 	Kind            ImageKind
 	Name            string
@@ -25,10 +47,46 @@ type Image struct {
 
 	DockerID string
 
+	// shown in the ssh menu
+	Description string
+	Category    string
+
 	containerConfig  *container.Config
-	hostConfig       *container.HostConfig     // if needed
+	hostConfig       *container.HostConfig
 	networkingConfig *network.NetworkingConfig // if needed
 
+	// ssh images
+	ConnectionMethod ConnectionMethod
+	// command executed for every connection by the exec method
+	Command []string
+	// used by the connect method
+	ResizeMethod ResizeMethod
+	// sessions are recorded as asciicasts
+	Record bool
+
+	// tcp images
+	ListenAddr string
+	Identify   TCPIdentify
+	listener   net.Listener
+
+	access        accessPolicy
+	scheduler     scheduler
+	schedulerName string
+	// limit of the number of containers of this image
+	limiter *limiter
+
+	network     networkPolicy
+	networkLock sync.Mutex
+	// managed network of the image, created on the first launch
+	networkName string
+
+	// Idle pre-started containers. Min are kept ready, up to Max idle
+	// containers are kept instead of being deleted
+	Pool struct {
+		Min int
+		Max int
+	}
+
 	PersistBetweenReconnects bool
 	Timeouts                 struct {
 		Total    time.Duration
@@ -40,6 +98,25 @@ type Image struct {
 	getElectionStartSignalC chan chan struct{}
 	getCandidatesChan       chan chan contatinerCandidate
 
+	// deltas of the number of existing containers
+	containerCountC chan int
+	// idle containers ask if they could stay in the pool
+	warmRequestC chan chan bool
+	// warm container got a user or is gone
+	warmReleaseC chan struct{}
+	// closed when the image is removed from the list
+	removedC   chan struct{}
+	removeOnce sync.Once
+	// closed when the image is removed and all of its containers are gone
+	retiredC chan struct{}
+
+	// running containers of the image, by docker id
+	containersLock sync.Mutex
+	containers     map[string]*Container
+	// containers being launched, by their orca.internal.launch label,
+	// the GC must not touch them before they are registered
+	launching map[string]bool
+
 	containerLock       sync.Mutex
 	containerUsersByUID map[string]*ContainerUser
 	// containerUsersByDockerID map[string]*ContainerUser
@@ -47,21 +124,34 @@ type Image struct {
 }
 
 func (img *Image) String() string {
-	return fmt.Sprintf(`Image{name="%s", id=%s}`, img.Name, strings.Split(img.DockerID, ":")[1][:8])
+	return fmt.Sprintf(`Image{name="%s", id=%s}`, img.Name, mydocker.ShortID(img.DockerID))
 }
 
-func NewImage(jc jobcontroller.JobController, img *mydocker.Image) (*Image, error) {
-	oi := &Image{
+// Builds the image from its labels without starting anything.
+// All label errors are reported at once (as LabelErrors), warnings are about
+// labels that are likely mistakes, but don't prevent image from being served.
+func ParseImage(img *mydocker.Image) (oi *Image, warnings []string, err error) {
+	oi = &Image{
 		DockerID:                img.ID,
 		getCandidatesChan:       make(chan chan contatinerCandidate),
 		electionRequestC:        make(chan chan contatinerCandidate),
 		electionStopC:           make(chan struct{}),
 		getElectionStartSignalC: make(chan chan struct{}),
 		containerUsersByUID:     make(map[string]*ContainerUser),
+		containers:              make(map[string]*Container),
+		launching:               make(map[string]bool),
+		containerCountC:         make(chan int),
+		warmRequestC:            make(chan chan bool),
+		warmReleaseC:            make(chan struct{}),
+		removedC:                make(chan struct{}),
+		retiredC:                make(chan struct{}),
 		// containerUsersByDockerID: make(map[string]*ContainerUser),
 	}
+	lp := newLabelParser(img)
+	warnings = lp.Warnings()
+
 	var found bool
-	oi.Name, found = img.Get("orca.name")
+	oi.Name, found = lp.Lookup("orca.name")
 	if !found {
 		if len(img.RepoTags) > 0 {
 			oi.Name = mydocker.Normalise(strings.Split(img.RepoTags[0], ":")[0])
@@ -69,32 +159,42 @@ func NewImage(jc jobcontroller.JobController, img *mydocker.Image) (*Image, erro
 		}
 	}
 	if !found {
-		return nil, errors.New("Can't find the name")
+		return nil, warnings, errors.New("Can't find the name")
 	}
 
-	oi.Kind, found = img.Get("orca.kind")
+	oi.Kind, found = lp.Lookup("orca.kind")
 	if !found {
-		return nil, errors.New("Can't find the kind")
+		return nil, warnings, errors.New("Can't find the kind")
 	}
-	jc.Logger.Logf("Found image %s of kind %s", oi.Name, oi.Kind)
 
 	oi.containerConfig = img.Config
 
-	switch oi.Kind {
-	case ImageKindWeb:
-		oi.PersistBetweenReconnects = img.GetBoolDefault("orca.container.persistBetweenReconnects", true)
-		oi.ConcurrentUsers = img.GetIntDefault("orca.users.concurrent", -1)
-		oi.TotalUsers = img.GetIntDefault("orca.users.total", -1)
-		// Expose ports
-		port, err := nat.NewPort("tcp", img.GetDefault("orca.port", "80"))
-		if err != nil {
-			return nil, errors.WithMessage(err, "parsing port")
+	parsePort := func(required bool, defaultPort string) {
+		portStr, found := lp.Lookup("orca.port")
+		if !found {
+			if required {
+				lp.Errorf("orca.port is required")
+				return
+			}
+			portStr = defaultPort
+		}
+		port, err := nat.NewPort("tcp", portStr)
+		if err != nil || port.Int() <= 0 {
+			lp.Errorf(`orca.port: invalid port "%s"`, portStr)
+			return
 		}
 		oi.Port = port.Int()
-
-		// TODO: do we need this?? Maybe just lookup port?
 		oi.containerConfig.ExposedPorts = make(map[nat.Port]struct{})
 		oi.containerConfig.ExposedPorts[port] = struct{}{}
+	}
+
+	switch oi.Kind {
+	case ImageKindWeb:
+		oi.PersistBetweenReconnects = lp.Bool("orca.container.persistBetweenReconnects", true)
+		oi.ConcurrentUsers = lp.Int("orca.users.concurrent", -1)
+		oi.TotalUsers = lp.Int("orca.users.total", -1)
+		// TODO: do we need this?? Maybe just lookup port?
+		parsePort(false, "80")
 
 		// HACK FOR TESTING
 		// oi.hostConfig = &container.HostConfig{
@@ -109,37 +209,116 @@ func NewImage(jc jobcontroller.JobController, img *mydocker.Image) (*Image, erro
 		// }
 
 	case ImageKindTCP:
-		return nil, errors.Errorf("image kind \"%s\" is not implemented", oi.Kind)
+		oi.PersistBetweenReconnects = lp.Bool("orca.container.persistBetweenReconnects", false)
+		oi.ConcurrentUsers = lp.Int("orca.users.concurrent", 1)
+		oi.TotalUsers = lp.Int("orca.users.total", 1)
+		parsePort(true, "")
+
+		oi.ListenAddr, found = lp.Lookup("orca.tcp.listen")
+		if !found {
+			lp.Errorf("orca.tcp.listen is required")
+		}
+		oi.Identify = lp.String("orca.tcp.identify", TCPIdentifyIP)
+		switch oi.Identify {
+		case TCPIdentifyIP, TCPIdentifyToken:
+		default:
+			lp.Errorf(`orca.tcp.identify: unknown identification method "%s"`, oi.Identify)
+		}
+
 	case ImageKindSSH:
-		oi.PersistBetweenReconnects = img.GetBoolDefault("orca.container.persistBetweenReconnects", false)
-		oi.ConcurrentUsers = img.GetIntDefault("orca.users.concurrent", 1)
-		oi.TotalUsers = img.GetIntDefault("orca.users.total", 1)
+		oi.PersistBetweenReconnects = lp.Bool("orca.container.persistBetweenReconnects", false)
+		oi.ConcurrentUsers = lp.Int("orca.users.concurrent", 1)
+		oi.TotalUsers = lp.Int("orca.users.total", 1)
 
-		cm := img.GetDefault("orca.connection.method", "attach")
-		switch cm {
-		case "attach":
+		oi.ConnectionMethod = lp.String("orca.connection.method", ConnectionMethodAttach)
+		switch oi.ConnectionMethod {
+		case ConnectionMethodAttach:
 			oi.containerConfig.AttachStdin = true
 			oi.containerConfig.AttachStdout = true
 			oi.containerConfig.AttachStderr = true
-			oi.containerConfig.Tty = img.GetBoolDefault("orca.container.tty", true)
-			oi.containerConfig.NetworkDisabled = img.GetBoolDefault("orca.container.networkdisabled", true)
+			oi.containerConfig.Tty = lp.Bool("orca.container.tty", true)
+			oi.containerConfig.NetworkDisabled = lp.Bool("orca.container.networkdisabled", true)
 			oi.containerConfig.OpenStdin = true
 			oi.containerConfig.StdinOnce = oi.TotalUsers == 1 // TODO: think about this
 
-		case "connect", "exec":
-			return nil, errors.Errorf("connection method \"%s\" is not implemented", cm)
+		case ConnectionMethodExec:
+			// Main process only keeps the container alive (like "docker run -dit"),
+			// every connection gets its own process via "docker exec"
+			oi.containerConfig.AttachStdin = false
+			oi.containerConfig.AttachStdout = false
+			oi.containerConfig.AttachStderr = false
+			oi.containerConfig.Tty = lp.Bool("orca.container.tty", true)
+			oi.containerConfig.NetworkDisabled = lp.Bool("orca.container.networkdisabled", true)
+			oi.containerConfig.OpenStdin = true
+			oi.containerConfig.StdinOnce = false
+
+			cmd := lp.String("orca.connection.command", "/bin/sh")
+			oi.Command, err = shlex.Split(cmd, true)
+			if err != nil {
+				lp.Errorf("orca.connection.command: %s", err)
+			} else if len(oi.Command) == 0 {
+				lp.Errorf("orca.connection.command is empty")
+			}
+
+		case ConnectionMethodConnect:
+			// Orca dials the port inside of the container, network is required
+			parsePort(true, "")
+			oi.containerConfig.NetworkDisabled = false
+
+			oi.ResizeMethod = lp.String("orca.connection.resize", ResizeMethodNone)
+			switch oi.ResizeMethod {
+			case ResizeMethodNone, ResizeMethodTelnet:
+			default:
+				lp.Errorf(`orca.connection.resize: unknown resize method "%s"`, oi.ResizeMethod)
+			}
+
 		default:
-			return nil, errors.Errorf("unknown connection method \"%s\"", cm)
+			lp.Errorf(`orca.connection.method: unknown connection method "%s"`, oi.ConnectionMethod)
 		}
+		oi.Record = lp.Bool("orca.record", false)
 	default:
-		return nil, errors.Errorf("unknown image kind \"%s\"", oi.Kind)
+		return nil, warnings, errors.Errorf("unknown image kind \"%s\"", oi.Kind)
 
 	}
 	// Common config parsing
-	oi.Timeouts.Total = img.GetDurationDefault("orca.timeout.session", 24*time.Hour)
-	oi.Timeouts.Inactive = img.GetDurationDefault("orca.timeout.inactive", 15*time.Minute)
+	oi.Description = strings.TrimSpace(lp.String("orca.description", ""))
+	oi.Category = strings.TrimSpace(lp.String("orca.category", ""))
+	if oi.ConcurrentUsers == 0 || oi.TotalUsers == 0 {
+		lp.Errorf("orca.users.*: container has to accept at least one user")
+	}
+
+	oi.access = parseAccessPolicy(lp)
+
+	maxContainers := lp.Int("orca.containers.max", -1)
+	oi.limiter = newLimiter(maxContainers)
+
+	oi.Pool.Min = lp.Int("orca.pool.min", 0)
+	oi.Pool.Max = lp.Int("orca.pool.max", oi.Pool.Min)
+	if oi.Pool.Min < 0 || oi.Pool.Max < oi.Pool.Min {
+		lp.Errorf("orca.pool.*: invalid pool size: min=%d max=%d", oi.Pool.Min, oi.Pool.Max)
+	}
+	if maxContainers >= 0 && oi.Pool.Min > maxContainers {
+		lp.Errorf("orca.pool.min: %d is over the container limit %d", oi.Pool.Min, maxContainers)
+	}
+
+	oi.hostConfig = &container.HostConfig{}
+	parseLimits(lp, oi.hostConfig)
+	parseSecurity(lp, oi.containerConfig, oi.hostConfig)
+	oi.network = parseNetworkPolicy(lp, oi.containerConfig, oi.hostConfig)
+	if oi.network.mode == NetworkModeNone && oi.Port != 0 {
+		lp.Errorf("orca.network.mode: orca has to reach the port, but the network is disabled")
+	}
 
-	oi.containerConfig.StopSignal = img.GetDefault(
+	oi.schedulerName = lp.String("orca.scheduler", defaultScheduler)
+	oi.scheduler, found = schedulers[oi.schedulerName]
+	if !found {
+		lp.Errorf(`orca.scheduler: unknown scheduler "%s"`, oi.schedulerName)
+	}
+
+	oi.Timeouts.Total = lp.Duration("orca.timeout.session", 24*time.Hour)
+	oi.Timeouts.Inactive = lp.Duration("orca.timeout.inactive", 15*time.Minute)
+
+	oi.containerConfig.StopSignal = lp.String(
 		"orca.container.stopsignal", oi.containerConfig.StopSignal,
 	)
 
@@ -148,13 +327,86 @@ func NewImage(jc jobcontroller.JobController, img *mydocker.Image) (*Image, erro
 		"orca.internal.imagename": oi.Name,
 	}
 
+	err = lp.Err()
+	if err != nil {
+		return nil, warnings, err
+	}
+	return oi, warnings, nil
+}
+
+// Starts serving the parsed image. Errors are about the resources
+// (like a busy tcp port), not the image itself, so it's worth retrying.
+func (oi *Image) start(jc jobcontroller.JobController) (err error) {
+	if oi.Kind == ImageKindTCP {
+		oi.listener, err = net.Listen("tcp", oi.ListenAddr)
+		if err != nil {
+			return errors.WithMessage(err, "listening on "+oi.ListenAddr)
+		}
+		jc.Job.Add(1)
+		go oi.serveTCP(jc)
+	}
+
 	go oi.manageImageState(jc)
 
-	return oi, nil
+	return nil
 }
 
+var ImageRemovedErr = errors.New("image was removed")
+
+// Image stops accepting new users, existing containers keep serving
+// their current users. Once the last container is gone the image is retired.
 func (oi *Image) MarkRemoved() {
-	// TODO
+	oi.removeOnce.Do(func() {
+		close(oi.removedC)
+		if oi.listener != nil {
+			// synchronously, so the replacement could listen on the same address
+			oi.listener.Close()
+		}
+	})
+}
+
+func (oi *Image) IsRemoved() bool {
+	select {
+	case <-oi.removedC:
+		return true
+	default:
+		return false
+	}
+}
+
+// Closed when the image was removed and has no containers left
+func (oi *Image) Retired() <-chan struct{} {
+	return oi.retiredC
+}
+
+func (oi *Image) changeContainerCount(delta int) error {
+	select {
+	case oi.containerCountC <- delta:
+		return nil
+	case <-oi.retiredC:
+		return ImageRemovedErr
+	}
+}
+
+// Asks if the idle container could stay warm instead of being deleted
+func (oi *Image) requestWarm() bool {
+	if oi.Pool.Max == 0 {
+		return false
+	}
+	reply := make(chan bool, 1)
+	select {
+	case oi.warmRequestC <- reply:
+		return <-reply
+	case <-oi.retiredC:
+		return false
+	}
+}
+
+func (oi *Image) releaseWarm() {
+	select {
+	case oi.warmReleaseC <- struct{}{}:
+	case <-oi.retiredC:
+	}
 }
 
 func (oi *Image) deleteContainerUser(jc jobcontroller.JobController, cu *ContainerUser) {
@@ -169,6 +421,31 @@ func (oi *Image) deleteContainerUser(jc jobcontroller.JobController, cu *Contain
 	}
 }
 
+// Resources of every container, zero if unlimited
+type ResourceLimits struct {
+	// bytes
+	Memory int64
+	CPUs   float64
+	Pids   int64
+}
+
+func (oi *Image) ResourceLimits() (limits ResourceLimits) {
+	limits.Memory = oi.hostConfig.Memory
+	limits.CPUs = float64(oi.hostConfig.NanoCPUs) / 1e9
+	if oi.hostConfig.PidsLimit != nil {
+		limits.Pids = *oi.hostConfig.PidsLimit
+	}
+	return
+}
+
+// User has a starting or working container of the image, it would be reused
+func (oi *Image) HasRunningContainer(ui *User) bool {
+	oi.containerLock.Lock()
+	cu := oi.containerUsersByUID[ui.ID]
+	oi.containerLock.Unlock()
+	return cu != nil && cu.IsAlive()
+}
+
 func (oi *Image) GetContainerUser(jc jobcontroller.JobController, ui *User) (cu *ContainerUser) {
 	// Atomic lookup for ContainerUser
 	// Returns object for interaction with container from the POV of the user/(handler)
@@ -186,19 +463,31 @@ func (oi *Image) GetContainerUser(jc jobcontroller.JobController, ui *User) (cu
 	return newCu
 }
 
-// Channeled createContainer
-func (oi *Image) getContainerC(jc jobcontroller.JobController, ui *User) (<-chan *Container, <-chan error) {
+// Channeled createContainer. Queue position is sent to positionC while waiting
+// for capacity, abandonedC is closed if the container isn't needed anymore.
+func (oi *Image) getContainerC(jc jobcontroller.JobController, ui *User, abandonedC <-chan struct{}, positionC chan<- int) (<-chan *Container, <-chan error) {
 	ocC := make(chan *Container)
 	errC := make(chan error)
 	jc.Job.Add(1)
 	go func() {
 		defer jc.Job.Done()
-		oc, err := oi.getContainer(jc)
+		oc, err := oi.getContainer(jc, abandonedC, positionC)
 		if err != nil {
-			errC <- err
+			select {
+			case errC <- err:
+			case <-abandonedC:
+			}
 			return
 		}
-		ocC <- oc
+		select {
+		case ocC <- oc:
+		case <-abandonedC:
+			// give up the reservation
+			select {
+			case oc.candidacyResponce <- nil:
+			case <-jc.Done():
+			}
+		}
 	}()
 	return ocC, errC
 }
@@ -210,7 +499,44 @@ func (oi *Image) manageImageState(jc jobcontroller.JobController) {
 	electionRequestChan := oi.electionRequestC
 	var voteStop chan struct{}
 
+	defer close(oi.retiredC)
+	containerCount := 0
+	removedC := oi.removedC
+	isRemoved := false
+
+	// pool state
+	warmCount := 0
+	launching := 0
+	poolLaunchedC := make(chan error)
+	var poolRetryC <-chan time.Time
+	poolJc := jc.AddLoggerPrefix("Pool").AddLoggerField(mylog.FieldImage, oi.Name)
+
 	for {
+		if isRemoved && containerCount == 0 && curElectionCandidatesC == nil && launching == 0 {
+			jc.Logger.Logf("%s is retired", oi)
+			oi.removeNetwork(jc)
+			return
+		}
+		for !isRemoved && poolRetryC == nil && !jc.IsShuttingDown() &&
+			warmCount+launching < oi.Pool.Min {
+			launching++
+			go func() {
+				var err error
+				// pool doesn't jump the queue
+				if oi.tryReserveSlot(false) {
+					_, err = oi.launchContainer(poolJc, true)
+					if err != nil {
+						oi.releaseSlot()
+					}
+				} else {
+					err = noCapacityErr
+				}
+				select {
+				case poolLaunchedC <- err:
+				case <-jc.Done():
+				}
+			}()
+		}
 		select {
 		// give out vote signal chan to anyone who'll ask
 		// asker takes one
@@ -244,17 +570,51 @@ func (oi *Image) manageImageState(jc jobcontroller.JobController) {
 			getCandidatesChan = nil
 			// reset current candidatesC (just to be nice)
 			curElectionCandidatesC = nil
+		case delta := <-oi.containerCountC:
+			containerCount += delta
+
+		case err := <-poolLaunchedC:
+			launching--
+			if err != nil {
+				if err != noCapacityErr {
+					poolJc.Logger.Warn.Err(err, "failed to launch a pooled container")
+				}
+				poolRetryC = time.After(poolRetryDelay)
+			} else {
+				// launched warm
+				warmCount++
+			}
+		case <-poolRetryC:
+			poolRetryC = nil
+		case reply := <-oi.warmRequestC:
+			warm := !isRemoved && warmCount < oi.Pool.Max
+			if warm {
+				warmCount++
+			}
+			reply <- warm
+		case <-oi.warmReleaseC:
+			warmCount--
+
+		case <-removedC:
+			removedC = nil
+			isRemoved = true
 		case <-jc.Done():
 			return
 		}
 	}
 }
 
-var electionsLength = 5 * time.Millisecond
+// Delay before the next pooled container is launched after a failure
+var poolRetryDelay = 30 * time.Second
+
+// Finds a container with a free spot. Creates new container if no free spots were found during the election,
+// waiting in the queue if the container limit is reached
+func (oi *Image) getContainer(jc jobcontroller.JobController, abandonedC <-chan struct{}, positionC chan<- int) (oc *Container, err error) {
+	jc = jc.AddLoggerField(mylog.FieldImage, oi.Name)
 
-// Finds a container with a free spot. Creates new container if no free spots were found in 5ms
-func (oi *Image) getContainer(jc jobcontroller.JobController) (oc *Container, err error) {
-	jc = jc.AddLoggerPrefix(fmt.Sprintf("Image %s", oi.Name))
+	if oi.IsRemoved() {
+		return nil, ImageRemovedErr
+	}
 
 	var requestTimer *time.Timer
 	var requestTimerC <-chan time.Time
@@ -266,12 +626,22 @@ func (oi *Image) getContainer(jc jobcontroller.JobController) (oc *Container, er
 
 	var electionDeadline <-chan time.Time
 
+	// slot for the new container, passed to it on launch
+	hasSlot := false
+	defer func() {
+		if hasSlot {
+			oi.releaseSlot()
+		}
+	}()
+
 	candidatesC := make(chan contatinerCandidate)
 	electionRequestC := oi.electionRequestC
+	electionStart := time.Now()
 	for {
 		select {
 		case electionRequestC <- candidatesC:
-			jc.Logger.Log("started election, stopping in 5ms")
+			electionsLength := config.Get().Containers.ElectionLength
+			jc.Logger.Log("started election, stopping in ", electionsLength)
 			electionDeadline = time.After(electionsLength)
 			electionRequestC = nil
 		case candidate := <-candidatesC:
@@ -282,18 +652,16 @@ func (oi *Image) getContainer(jc jobcontroller.JobController) (oc *Container, er
 			jc.Logger.Log("Stopping the elections")
 			oi.electionStopC <- struct{}{}
 			candidatesC = nil
+			metrics.ElectionDuration.WithLabelValues(oi.Name).Observe(metrics.Since(electionStart))
+			outcome := "none"
+			if len(candidates) > 0 {
+				outcome = "candidate"
+			}
+			metrics.Elections.WithLabelValues(oi.Name, outcome).Inc()
 			// Evaluate candidates
 			jc.Logger.Debug.Log("Election candidates: ", len(candidates), " ", candidates)
 			if len(candidates) > 0 {
-				best := 0
-				for n, candidate := range candidates {
-					// Scheduler packs users into as little containers as possible
-					// ToDo: various sheduler improvements, for example spread users
-					// equaly over minimal number of containers
-					if candidate.concurrentUsers > candidates[best].concurrentUsers {
-						best = n
-					}
-				}
+				best := oi.scheduler.Pick(candidates)
 				oc = candidates[best].container
 				jc.Logger.Debug.Log("best: ", candidates[best], " ", oc)
 				if len(candidates) > 1 {
@@ -321,10 +689,30 @@ func (oi *Image) getContainer(jc jobcontroller.JobController) (oc *Container, er
 			}
 
 		case <-requestTimerC:
+			if oi.IsRemoved() {
+				return nil, ImageRemovedErr
+			}
+			if !hasSlot {
+				var waited bool
+				waited, err = oi.reserveSlot(jc, abandonedC, positionC)
+				if err != nil {
+					return nil, err
+				}
+				hasSlot = true
+				if waited {
+					// some container might have a free spot by now
+					jc.Logger.Log("Got the slot after waiting, holding elections again")
+					candidates = nil
+					candidatesC = make(chan contatinerCandidate)
+					electionRequestC = oi.electionRequestC
+					electionStart = time.Now()
+					continue
+				}
+			}
 			attamptsRemainings--
 			jc.Logger.Log("Requesting container creation for image ", oi.Name)
 
-			oc, err = oi.launchContainer(jc)
+			oc, err = oi.launchContainer(jc, false)
 			jc.Logger.Err(err, "container creation error")
 			if err != nil {
 				if attamptsRemainings > 0 {
@@ -336,13 +724,13 @@ func (oi *Image) getContainer(jc jobcontroller.JobController) (oc *Container, er
 					return nil, errors.WithMessage(err, "retry exceeded")
 				}
 			}
+			// slot belongs to the container now
+			hasSlot = false
 			return
+		case <-oi.retiredC:
+			return nil, ImageRemovedErr
 		case <-jc.Done():
 			return nil, jc.Err()
 		}
 	}
 }
-
-func (oi *Image) IsVisibleTo(ui *User) bool {
-	return true
-}
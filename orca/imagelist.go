@@ -1,8 +1,9 @@
 package orca
 
 import (
-	"strings"
+	"context"
 	"sync"
+	"time"
 
 	"github.com/Andrew-Morozko/orca/jobcontroller"
 	"github.com/Andrew-Morozko/orca/orca/errctrl"
@@ -27,9 +28,18 @@ type ImageList struct {
 	lock                sync.Mutex
 	imagesByKindAndName map[ImageKind]map[string]*Image
 	imagesByDockerID    map[string]*Image
-	// TODO rn just storing the images in the map. Remove them
-	// when all the clients have gone
+	// images that are still serving their users, dropped when retired
 	removedImages map[string]*Image
+	// images that failed to parse, not parsed again until changed
+	invalidImages map[string]error
+	// images drained by the admin, not served again until changed
+	drainedImages map[string]bool
+
+	// only one update at a time
+	updateLock sync.Mutex
+	reloadC    chan struct{}
+
+	createdAt time.Time
 }
 
 func NewImageList(jc jobcontroller.JobController) (il *ImageList, err error) {
@@ -40,73 +50,221 @@ func NewImageList(jc jobcontroller.JobController) (il *ImageList, err error) {
 		imagesByKindAndName: make(map[ImageKind]map[string]*Image),
 		imagesByDockerID:    make(map[string]*Image),
 		removedImages:       make(map[string]*Image),
+		invalidImages:       make(map[string]error),
+		drainedImages:       make(map[string]bool),
+		reloadC:             make(chan struct{}, 1),
+		createdAt:           time.Now(),
 	}
 	for _, kind := range ImageKinds {
 		il.imagesByKindAndName[kind] = make(map[string]*Image)
 	}
 
 	err = il.UpdateImages(jc)
+	if err == imagesNotStartedErr {
+		// watcher retries them
+		il.Reload()
+		err = nil
+	}
 	if err != nil {
 		return
 	}
+
+	// Leftovers from the previous run
+	err = il.reconcileContainers(jc, true)
+	if err != nil {
+		jc.Logger.Warn.Err(err, "failed to reconcile containers")
+		err = nil
+	}
+
+	jc.Job.Add(2)
+	go il.watchImages(jc)
+	go il.collectGarbage(jc)
 	return il, nil
 }
 
 func (il *ImageList) UpdateImages(jc jobcontroller.JobController) (err error) {
-	newImages, err := Docker.ListLabeledImages(jc)
+	il.updateLock.Lock()
+	defer il.updateLock.Unlock()
+
+	newIDs, err := Docker.ListLabeledImageIDs(jc)
 	if err != nil {
 		return err
 	}
 
-	newImgsById := make(map[string]*mydocker.Image)
-	for _, img := range newImages {
-		newImgsById[img.ID] = img
+	isNew := make(map[string]bool, len(newIDs))
+	for _, id := range newIDs {
+		isNew[id] = true
 	}
 
 	jc.Logger.Log("Performing image update")
 
-	il.lock.Lock()
-	defer il.lock.Unlock()
-
-	var imgsToAdd []*mydocker.Image
+	var idsToAdd []string
 	var imgsToRemove []*Image
 
+	il.lock.Lock()
 	for dockerId, img := range il.imagesByDockerID {
-		_, foundInNew := newImgsById[dockerId]
-		if !foundInNew {
+		if !isNew[dockerId] {
 			imgsToRemove = append(imgsToRemove, img)
 		}
 	}
-	for dockerId, img := range newImgsById {
+	for dockerId := range il.invalidImages {
+		if !isNew[dockerId] {
+			delete(il.invalidImages, dockerId)
+		}
+	}
+	for dockerId := range il.drainedImages {
+		if !isNew[dockerId] {
+			delete(il.drainedImages, dockerId)
+		}
+	}
+	for _, dockerId := range newIDs {
 		_, foundInOld := il.imagesByDockerID[dockerId]
-		if !foundInOld {
-			imgsToAdd = append(imgsToAdd, img)
+		_, isInvalid := il.invalidImages[dockerId]
+		if !foundInOld && !isInvalid && !il.drainedImages[dockerId] {
+			idsToAdd = append(idsToAdd, dockerId)
+		}
+	}
+	il.lock.Unlock()
+
+	jc.Logger.Logf("%d images to add, %d images to remove", len(idsToAdd), len(imgsToRemove))
+
+	// Only the new images are inspected
+	imgsToAdd := make([]*mydocker.Image, 0, len(idsToAdd))
+	for _, id := range idsToAdd {
+		img, err := Docker.InspectImage(jc, id)
+		if err != nil {
+			jc.Logger.Errf(err, "failed to inspect image %s", mydocker.ShortID(id))
+			continue
 		}
+		imgsToAdd = append(imgsToAdd, img)
 	}
 
-	jc.Logger.Logf("%d images to add, %d images to remove", len(imgsToAdd), len(imgsToRemove))
+	il.lock.Lock()
+	defer il.lock.Unlock()
 
+	// Removing first, replacements may need resources (like tcp ports) of the old images
+	for _, img := range imgsToRemove {
+		il.removeImage(img)
+	}
+
+	failed := 0
 	for _, img := range imgsToAdd {
-		imgToAdd, err := NewImage(jc, img)
+		imgToAdd, warnings, err := ParseImage(img)
 		if err != nil {
 			jc.Logger.Errf(err,
-				"Failed to parse image %s: %s",
-				strings.Split(img.ID, ":")[1][:8],
+				"Failed to parse image %s",
+				mydocker.ShortID(img.ID),
 			)
+			il.invalidImages[img.ID] = err
 			continue
 		}
+		jc.Logger.Logf("Found image %s of kind %s", imgToAdd.Name, imgToAdd.Kind)
+		for _, warning := range warnings {
+			jc.Logger.Warn.Logf("%s: %s", imgToAdd, warning)
+		}
+		err = imgToAdd.start(jc)
+		if err != nil {
+			// not invalid, tried again on the next update
+			jc.Logger.Errf(err, "Failed to start %s", imgToAdd)
+			failed++
+			continue
+		}
+		if prev, found := il.imagesByKindAndName[imgToAdd.Kind][imgToAdd.Name]; found {
+			jc.Logger.Warn.Logf("%s replaces %s with the same name", imgToAdd, prev)
+		}
 		il.imagesByDockerID[imgToAdd.DockerID] = imgToAdd
 		il.imagesByKindAndName[imgToAdd.Kind][imgToAdd.Name] = imgToAdd
 	}
 
-	for _, img := range imgsToRemove {
-		delete(il.imagesByDockerID, img.DockerID)
+	if failed != 0 {
+		jc.Logger.Warn.Logf("%d images failed to start", failed)
+		return imagesNotStartedErr
+	}
+	return nil
+}
+
+var imagesNotStartedErr = errors.New("some images failed to start")
+
+// Image keeps serving its current users until retired. Must hold il.lock
+func (il *ImageList) removeImage(img *Image) {
+	delete(il.imagesByDockerID, img.DockerID)
+	if il.imagesByKindAndName[img.Kind][img.Name] == img {
 		delete(il.imagesByKindAndName[img.Kind], img.Name)
-		il.removedImages[img.DockerID] = img
-		img.MarkRemoved()
 	}
+	il.removedImages[img.DockerID] = img
+	img.MarkRemoved()
+	go il.forgetWhenRetired(img)
+}
 
-	return nil
+func (il *ImageList) forgetWhenRetired(img *Image) {
+	<-img.Retired()
+	il.lock.Lock()
+	if il.removedImages[img.DockerID] == img {
+		delete(il.removedImages, img.DockerID)
+	}
+	il.lock.Unlock()
+}
+
+// Requests the rescan of the images
+func (il *ImageList) Reload() {
+	select {
+	case il.reloadC <- struct{}{}:
+	default:
+		// reload is already pending
+	}
+}
+
+// Docker sends several events for a single build/pull, they are batched
+var imageUpdateDelay = time.Second
+var eventsRetryDelay = 5 * time.Second
+
+// Updates the images on docker events and Reload requests
+func (il *ImageList) watchImages(jc jobcontroller.JobController) {
+	defer jc.Job.Done()
+	jc = jc.AddLoggerPrefix("Image watcher")
+
+	ctx, cancel := context.WithCancel(jc)
+	defer cancel()
+
+	eventsC, eventsErrC := Docker.ImageEvents(ctx)
+	var retryC <-chan time.Time
+	var updateC <-chan time.Time
+	scheduleUpdate := func(delay time.Duration) {
+		if updateC == nil {
+			updateC = time.After(delay)
+		}
+	}
+
+	for {
+		select {
+		case ev := <-eventsC:
+			jc.Logger.Debug.Logf("Got image event %s %s", ev.Action, ev.Actor.ID)
+			scheduleUpdate(imageUpdateDelay)
+		case err := <-eventsErrC:
+			jc.Logger.Warn.Err(err, "docker events stream failed")
+			eventsC, eventsErrC = nil, nil
+			retryC = time.After(eventsRetryDelay)
+		case <-retryC:
+			retryC = nil
+			eventsC, eventsErrC = Docker.ImageEvents(ctx)
+			// events could have been missed
+			scheduleUpdate(0)
+		case <-il.reloadC:
+			jc.Logger.Log("Reload requested")
+			scheduleUpdate(0)
+		case <-updateC:
+			updateC = nil
+			err := il.UpdateImages(jc)
+			if err != nil {
+				jc.Logger.Err(err, "image update failed")
+				scheduleUpdate(eventsRetryDelay)
+			}
+		case <-jc.ShutdownRequested():
+			return
+		case <-jc.Done():
+			return
+		}
+	}
 }
 
 func (il *ImageList) GetImages(kind ImageKind, ui *User) map[string]*Image {
@@ -90,68 +90,83 @@ var ForsedShutdown = errors.New("Forsed Shutdown")
 
 func (cm *CopyMonitor) AddCopier(dst io.Writer, src io.Reader) {
 	go func() {
-		buf := make([]byte, cm.bufSize)
-		var err error
+		cm.finish(cm.copy(dst, src))
+	}()
+}
 
-		ctxDone := cm.ctx.Done()
-	loop:
-		for {
+// Like AddCopier, but EOF of src only closes dst for writing (e.g. stdin of
+// the container), the monitor keeps running until the other copiers are done
+func (cm *CopyMonitor) AddHalfCloseCopier(dst io.Writer, src io.Reader, closeWrite func() error) {
+	go func() {
+		err := cm.copy(dst, src)
+		if err == io.EOF {
+			err = closeWrite()
+			if err == nil {
+				return
+			}
+		}
+		cm.finish(err)
+	}()
+}
+
+// Copying that doesn't fit into a reader and a writer, e.g. stdcopy.StdCopy
+func (cm *CopyMonitor) AddCopyFunc(copyFunc func() error) {
+	go func() {
+		cm.finish(copyFunc())
+	}()
+}
+
+func (cm *CopyMonitor) copy(dst io.Writer, src io.Reader) (err error) {
+	buf := make([]byte, cm.bufSize)
+	ctxDone := cm.ctx.Done()
+	for {
+		select {
+		case <-cm.ticker.C:
 			select {
-			case <-cm.ticker.C:
-				select {
-				case <-cm.notificationChan:
-				default:
-				}
-			case <-ctxDone:
-				err = ForsedShutdown
-				break loop
+			case <-cm.notificationChan:
 			default:
-				// copy the data
-				nr, er := src.Read(buf)
-				if nr > 0 {
-					nw, ew := dst.Write(buf[0:nr])
-					if ew != nil {
-						err = ew
-						break loop
-					}
-					if nr != nw {
-						err = io.ErrShortWrite
-						break loop
-					}
+			}
+		case <-ctxDone:
+			return ForsedShutdown
+		default:
+			// copy the data
+			nr, er := src.Read(buf)
+			if nr > 0 {
+				nw, ew := dst.Write(buf[0:nr])
+				if ew != nil {
+					return ew
 				}
-				if er != nil {
-					err = er
-					break loop
+				if nr != nw {
+					return io.ErrShortWrite
 				}
 			}
+			if er != nil {
+				return er
+			}
 		}
+	}
+}
 
-		var isCorrect, imFirst bool
-		cm.lock.Lock()
-		if !cm.isDone {
-			imFirst = true
-			isCorrect = err == io.EOF || err == nil
-			cm.isDone = true
-			cm.ticker.Stop()
-			cm.isCorrect = isCorrect
-			cm.err = err
-		}
-		cm.lock.Unlock()
-
-		// if c, ok := src.(io.Closer); ok {
-		// 	c.Close()
-		// }
-		// if c, ok := dst.(io.Closer); ok {
-		// 	c.Close()
-		// }
-
-		if imFirst {
-			if isCorrect {
-				// Give the other writers a chance to write last messages and close
-				// Force shutdown if it takes longer than a second
-				time.Sleep(time.Second)
-			}
-			cm.Close()
+// First finished copier shuts the monitor down
+func (cm *CopyMonitor) finish(err error) {
+	var isCorrect, imFirst bool
+	cm.lock.Lock()
+	if !cm.isDone {
+		imFirst = true
+		isCorrect = err == io.EOF || err == nil
+		cm.isDone = true
+		cm.ticker.Stop()
+		cm.isCorrect = isCorrect
+		cm.err = err
+	}
+	cm.lock.Unlock()
+
+	if imFirst {
+		if isCorrect {
+			// Give the other writers a chance to write last messages and close
+			// Force shutdown if it takes longer than a second
+			time.Sleep(time.Second)
 		}
-	}()
+		cm.Close()
+	}
 }
@@ -15,6 +15,11 @@ func (dp *DuplexPipe) Read(p []byte) (n int, err error) {
 func (dp *DuplexPipe) Write(p []byte) (n int, err error) {
 	return dp.w.Write(p)
 }
+
+// Reader of the other end gets EOF, reading still works
+func (dp *DuplexPipe) CloseWrite() error {
+	return dp.w.Close()
+}
 func (dp *DuplexPipe) Close() error {
 	dp.r.Close()
 	dp.w.Close()
@@ -40,9 +45,26 @@ func ProxyReadWriter(proxied io.ReadWriter) io.ReadWriteCloser {
 		p1.Close()
 	}()
 	go func() {
-		_, _ = io.Copy(p1, proxied)
-		p1.Close()
+		_, err := io.Copy(p1, proxied)
+		if err == nil {
+			// EOF, the other direction may still be in use
+			p1.CloseWrite()
+		} else {
+			p1.Close()
+		}
 	}()
 
 	return p2
 }
+
+// Reports the number of bytes written, e.g. to the metrics
+type CountingWriter struct {
+	io.Writer
+	Count func(n int)
+}
+
+func (cw *CountingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.Writer.Write(p)
+	cw.Count(n)
+	return
+}
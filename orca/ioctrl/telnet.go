@@ -0,0 +1,186 @@
+package ioctrl
+
+import (
+	"bufio"
+	"net"
+	"sync"
+)
+
+// Telnet protocol bytes (RFC 854)
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptEcho = 1
+	telnetOptSGA  = 3
+	telnetOptNAWS = 31
+)
+
+// Minimal client side of the telnet protocol: escapes IAC in the sent data,
+// strips negotiations from the received data and reports the window size
+// via NAWS (RFC 1073) once the server asks for it.
+type TelnetConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	// lock protects everything below and serializes writes
+	lock          sync.Mutex
+	nawsEnabled   bool
+	width, height int
+}
+
+func NewTelnetConn(conn net.Conn) *TelnetConn {
+	return &TelnetConn{
+		Conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+func (tc *TelnetConn) Read(p []byte) (n int, err error) {
+	var b byte
+	for n < len(p) {
+		if n > 0 && tc.r.Buffered() == 0 {
+			// don't block while holding data for the caller
+			return
+		}
+		b, err = tc.r.ReadByte()
+		if err != nil {
+			return
+		}
+		if b != telnetIAC {
+			p[n] = b
+			n++
+			continue
+		}
+
+		b, err = tc.r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case telnetIAC:
+			// escaped 0xFF
+			p[n] = b
+			n++
+		case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+			var opt byte
+			opt, err = tc.r.ReadByte()
+			if err != nil {
+				return
+			}
+			err = tc.negotiate(b, opt)
+			if err != nil {
+				return
+			}
+		case telnetSB:
+			// skip subnegotiation
+			var prev byte
+			for {
+				b, err = tc.r.ReadByte()
+				if err != nil {
+					return
+				}
+				if prev == telnetIAC && b == telnetSE {
+					break
+				}
+				if prev == telnetIAC && b == telnetIAC {
+					b = 0
+				}
+				prev = b
+			}
+		default:
+			// other commands carry no data
+		}
+	}
+	return
+}
+
+func (tc *TelnetConn) negotiate(cmd, opt byte) error {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	switch cmd {
+	case telnetDO:
+		if opt == telnetOptNAWS {
+			tc.nawsEnabled = true
+			_, err := tc.Conn.Write([]byte{telnetIAC, telnetWILL, opt})
+			if err != nil {
+				return err
+			}
+			return tc.sendWindowSize()
+		}
+		_, err := tc.Conn.Write([]byte{telnetIAC, telnetWONT, opt})
+		return err
+	case telnetDONT:
+		if opt == telnetOptNAWS {
+			tc.nawsEnabled = false
+		}
+		_, err := tc.Conn.Write([]byte{telnetIAC, telnetWONT, opt})
+		return err
+	case telnetWILL:
+		// server side echo and no go-ahead are what the pty on our end expects
+		if opt == telnetOptEcho || opt == telnetOptSGA {
+			_, err := tc.Conn.Write([]byte{telnetIAC, telnetDO, opt})
+			return err
+		}
+		_, err := tc.Conn.Write([]byte{telnetIAC, telnetDONT, opt})
+		return err
+	}
+	// WONT needs no reply
+	return nil
+}
+
+// must be called with the lock held
+func (tc *TelnetConn) sendWindowSize() error {
+	if !tc.nawsEnabled || tc.width == 0 || tc.height == 0 {
+		return nil
+	}
+	msg := []byte{telnetIAC, telnetSB, telnetOptNAWS}
+	for _, b := range []byte{
+		byte(tc.width >> 8), byte(tc.width),
+		byte(tc.height >> 8), byte(tc.height),
+	} {
+		msg = append(msg, b)
+		if b == telnetIAC {
+			msg = append(msg, telnetIAC)
+		}
+	}
+	msg = append(msg, telnetIAC, telnetSE)
+	_, err := tc.Conn.Write(msg)
+	return err
+}
+
+func (tc *TelnetConn) SetWindowSize(width, height int) error {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	tc.width, tc.height = width, height
+	return tc.sendWindowSize()
+}
+
+func (tc *TelnetConn) Write(p []byte) (n int, err error) {
+	buf := make([]byte, 0, len(p))
+	for _, b := range p {
+		buf = append(buf, b)
+		if b == telnetIAC {
+			buf = append(buf, telnetIAC)
+		}
+	}
+	tc.lock.Lock()
+	_, err = tc.Conn.Write(buf)
+	tc.lock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (tc *TelnetConn) CloseWrite() error {
+	if cw, ok := tc.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
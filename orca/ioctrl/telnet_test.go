@@ -0,0 +1,156 @@
+package ioctrl
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Client side wrapped in TelnetConn, everything it sends is collected into sentC.
+// Closing tc ends the collection.
+func telnetPipe() (tc *TelnetConn, server net.Conn, sentC <-chan []byte) {
+	client, server := net.Pipe()
+	sent := make(chan []byte, 100)
+	tc = NewTelnetConn(client)
+	go func() {
+		defer close(sent)
+		buf := make([]byte, 256)
+		for {
+			n, err := server.Read(buf)
+			if err != nil {
+				return
+			}
+			sent <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+	return tc, server, sent
+}
+
+func expectSent(t *testing.T, sentC <-chan []byte, expected []byte) {
+	t.Helper()
+	var got []byte
+	timeout := time.After(time.Second)
+	for len(got) < len(expected) {
+		select {
+		case msg := <-sentC:
+			got = append(got, msg...)
+		case <-timeout:
+			t.Fatalf("sent % x, expected % x", got, expected)
+		}
+	}
+	if !bytes.Equal(got, expected) {
+		t.Fatalf("sent % x, expected % x", got, expected)
+	}
+}
+
+// Read in the background, so replies to the negotiations don't deadlock the pipe
+func readFull(t *testing.T, tc *TelnetConn, n int) []byte {
+	t.Helper()
+	resC := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(tc, buf)
+		if err != nil {
+			buf = nil
+		}
+		resC <- buf
+	}()
+	select {
+	case res := <-resC:
+		return res
+	case <-time.After(time.Second):
+		t.Fatal("read timed out")
+		return nil
+	}
+}
+
+func TestTelnetWriteEscapesIAC(t *testing.T) {
+	tc, _, sentC := telnetPipe()
+	defer tc.Close()
+	n, err := tc.Write([]byte{'a', telnetIAC, 'b'})
+	if err != nil || n != 3 {
+		t.Fatalf("wrote %d: %v", n, err)
+	}
+	expectSent(t, sentC, []byte{'a', telnetIAC, telnetIAC, 'b'})
+}
+
+func TestTelnetReadStripsNegotiations(t *testing.T) {
+	tc, server, sentC := telnetPipe()
+	defer tc.Close()
+	go func() {
+		_, _ = server.Write([]byte{
+			'a',
+			telnetIAC, telnetWILL, telnetOptEcho,
+			'b',
+			// terminal type subnegotiation with an escaped IAC inside
+			telnetIAC, telnetSB, 24, 0, 'x', telnetIAC, telnetIAC, 'y', telnetIAC, telnetSE,
+			'c',
+			telnetIAC, telnetIAC,
+			telnetIAC, telnetWILL, 42,
+			'd',
+		})
+	}()
+	if got := readFull(t, tc, 5); !bytes.Equal(got, []byte{'a', 'b', 'c', telnetIAC, 'd'}) {
+		t.Errorf("read % x", got)
+	}
+	// echo is accepted, unknown options are refused
+	expectSent(t, sentC, []byte{
+		telnetIAC, telnetDO, telnetOptEcho,
+		telnetIAC, telnetDONT, 42,
+	})
+}
+
+func TestTelnetNAWS(t *testing.T) {
+	tc, server, sentC := telnetPipe()
+	defer tc.Close()
+	// not asked for yet
+	if err := tc.SetWindowSize(255, 80); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = server.Write([]byte{telnetIAC, telnetDO, telnetOptNAWS, 'z'})
+	}()
+	if got := readFull(t, tc, 1); !bytes.Equal(got, []byte{'z'}) {
+		t.Errorf("read % x", got)
+	}
+	// 255 is escaped in the subnegotiation
+	expectSent(t, sentC, []byte{
+		telnetIAC, telnetWILL, telnetOptNAWS,
+		telnetIAC, telnetSB, telnetOptNAWS, 0, telnetIAC, telnetIAC, 0, 80, telnetIAC, telnetSE,
+	})
+
+	if err := tc.SetWindowSize(300, 255); err != nil {
+		t.Fatal(err)
+	}
+	expectSent(t, sentC, []byte{
+		telnetIAC, telnetSB, telnetOptNAWS, 1, 44, 0, telnetIAC, telnetIAC, telnetIAC, telnetSE,
+	})
+}
+
+func TestTelnetReadReturnsBuffered(t *testing.T) {
+	tc, server, _ := telnetPipe()
+	defer tc.Close()
+	go func() {
+		_, _ = server.Write([]byte("hello"))
+	}()
+	if got := readFull(t, tc, 2); string(got) != "he" {
+		t.Fatalf("read %q", got)
+	}
+	// the rest is buffered, nothing else is coming
+	resC := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 100)
+		n, _ := tc.Read(buf)
+		resC <- string(buf[:n])
+	}()
+	select {
+	case got := <-resC:
+		if got != "llo" {
+			t.Errorf("read %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read blocked with the data buffered")
+	}
+}
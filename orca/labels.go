@@ -0,0 +1,357 @@
+package orca
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Andrew-Morozko/orca/orca/mydocker"
+	"github.com/docker/go-units"
+)
+
+type labelType int
+
+const (
+	// lowercased string
+	labelString labelType = iota
+	// case is preserved
+	labelRaw
+	labelInt
+	labelBool
+	labelDuration
+)
+
+// Every label orca understands. Keys are normalised (lowercased)
+var labelSchema = map[string]labelType{
+	"orca.enabled": labelString,
+	"orca.kind":    labelString,
+	"orca.name":    labelString,
+	"orca.port":    labelString,
+
+	"orca.description": labelRaw,
+	"orca.category":    labelRaw,
+
+	"orca.users.concurrent": labelInt,
+	"orca.users.total":      labelInt,
+
+	"orca.tcp.listen":   labelString,
+	"orca.tcp.identify": labelString,
+
+	"orca.connection.method":  labelString,
+	"orca.connection.command": labelRaw,
+	"orca.connection.resize":  labelString,
+
+	"orca.access.users":  labelString,
+	"orca.access.groups": labelString,
+	"orca.access.after":  labelRaw,
+	"orca.access.before": labelRaw,
+
+	"orca.scheduler":      labelString,
+	"orca.containers.max": labelInt,
+	"orca.pool.min":       labelInt,
+	"orca.pool.max":       labelInt,
+
+	"orca.record": labelBool,
+
+	"orca.timeout.session":  labelDuration,
+	"orca.timeout.inactive": labelDuration,
+
+	"orca.container.persistbetweenreconnects": labelBool,
+	"orca.container.tty":                      labelBool,
+	"orca.container.networkdisabled":          labelBool,
+	"orca.container.stopsignal":               labelRaw,
+	"orca.container.readonly":                 labelBool,
+	"orca.container.tmpfs":                    labelRaw,
+
+	"orca.limits.memory":  labelString,
+	"orca.limits.cpus":    labelString,
+	"orca.limits.pids":    labelInt,
+	"orca.limits.ulimits": labelString,
+	"orca.limits.storage": labelString,
+
+	"orca.security.profile":         labelString,
+	"orca.security.capabilities":    labelString,
+	"orca.security.nonewprivileges": labelBool,
+	"orca.security.seccomp":         labelRaw,
+	"orca.security.apparmor":        labelRaw,
+	"orca.security.runtime":         labelString,
+	"orca.security.userns":          labelString,
+	"orca.security.user":            labelRaw,
+
+	"orca.network.mode":    labelString,
+	"orca.network.isolate": labelBool,
+	"orca.network.egress":  labelString,
+	"orca.network.attach":  labelRaw,
+}
+
+// Image labels failed validation
+type LabelErrors []string
+
+func (le LabelErrors) Error() string {
+	return "invalid labels: " + strings.Join(le, "; ")
+}
+
+// Typed access to the image labels. Errors are collected instead of
+// returned, so all of them could be reported at once.
+type labelParser struct {
+	img  *mydocker.Image
+	errs LabelErrors
+}
+
+func newLabelParser(img *mydocker.Image) *labelParser {
+	return &labelParser{img: img}
+}
+
+func (lp *labelParser) checkType(key string, t labelType) {
+	regT, found := labelSchema[mydocker.Normalise(key)]
+	if !found || regT != t {
+		panic(fmt.Sprintf("label %s is not registered with type %d", key, t))
+	}
+}
+
+func (lp *labelParser) Errorf(format string, args ...interface{}) {
+	lp.errs = append(lp.errs, fmt.Sprintf(format, args...))
+}
+
+// nil if all labels are valid
+func (lp *labelParser) Err() error {
+	if len(lp.errs) == 0 {
+		return nil
+	}
+	return lp.errs
+}
+
+// Raw or lowercased value, according to the schema
+func (lp *labelParser) Lookup(key string) (string, bool) {
+	t, found := labelSchema[mydocker.Normalise(key)]
+	if !found {
+		panic(fmt.Sprintf("label %s is not registered", key))
+	}
+	switch t {
+	case labelString:
+		return lp.img.Get(key)
+	case labelRaw:
+		return lp.img.GetRaw(key)
+	}
+	panic(fmt.Sprintf("label %s is not a string", key))
+}
+
+func (lp *labelParser) String(key string, defaultVal string) string {
+	val, found := lp.Lookup(key)
+	if !found {
+		return defaultVal
+	}
+	return val
+}
+
+func (lp *labelParser) Int(key string, defaultVal int) int {
+	lp.checkType(key, labelInt)
+	val, found := lp.img.Get(key)
+	if !found {
+		return defaultVal
+	}
+	res, err := strconv.Atoi(val)
+	if err != nil {
+		lp.Errorf(`%s: invalid integer "%s"`, key, val)
+		return defaultVal
+	}
+	return res
+}
+
+// Value of the label if it's set. Malformed, zero and negative values are
+// reported, found is false for them.
+func (lp *labelParser) PositiveInt(key string) (res int, found bool) {
+	lp.checkType(key, labelInt)
+	val, found := lp.img.Get(key)
+	if !found {
+		return 0, false
+	}
+	res, err := strconv.Atoi(val)
+	if err != nil || res <= 0 {
+		lp.Errorf(`%s: must be a positive integer, got "%s"`, key, val)
+		return 0, false
+	}
+	return res, true
+}
+
+func (lp *labelParser) Bool(key string, defaultVal bool) bool {
+	lp.checkType(key, labelBool)
+	val, found := lp.img.Get(key)
+	if !found {
+		return defaultVal
+	}
+	res, err := strconv.ParseBool(val)
+	if err != nil {
+		lp.Errorf(`%s: invalid boolean "%s"`, key, val)
+		return defaultVal
+	}
+	return res
+}
+
+func (lp *labelParser) Duration(key string, defaultVal time.Duration) time.Duration {
+	lp.checkType(key, labelDuration)
+	val, found := lp.img.Get(key)
+	if !found {
+		return defaultVal
+	}
+	res, err := time.ParseDuration(val)
+	if err != nil || res <= 0 {
+		lp.Errorf(`%s: invalid duration "%s"`, key, val)
+		return defaultVal
+	}
+	return res
+}
+
+// orca.* labels that are not in the schema, most likely typos
+func (lp *labelParser) Warnings() (warnings []string) {
+	for key := range lp.img.Config.Labels {
+		key = mydocker.Normalise(key)
+		if !strings.HasPrefix(key, "orca.") {
+			continue
+		}
+		if _, found := labelSchema[key]; !found {
+			warnings = append(warnings, fmt.Sprintf("unknown label %s", key))
+		}
+	}
+	sort.Strings(warnings)
+	return
+}
+
+// Prints the configuration the image gets after parsing the labels
+func (oi *Image) WriteConfig(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	line := func(key string, val interface{}) {
+		fmt.Fprintf(tw, "%s\t%v\n", key, val)
+	}
+	limit := func(val int) interface{} {
+		if val < 0 {
+			return "unlimited"
+		}
+		return val
+	}
+
+	line("name", oi.Name)
+	line("kind", oi.Kind)
+	if oi.Description != "" {
+		line("description", oi.Description)
+	}
+	if oi.Category != "" {
+		line("category", oi.Category)
+	}
+	if oi.Port != 0 {
+		line("port", oi.Port)
+	}
+	switch oi.Kind {
+	case ImageKindTCP:
+		line("tcp.listen", oi.ListenAddr)
+		line("tcp.identify", oi.Identify)
+	case ImageKindSSH:
+		line("connection.method", oi.ConnectionMethod)
+		switch oi.ConnectionMethod {
+		case ConnectionMethodExec:
+			line("connection.command", strings.Join(oi.Command, " "))
+		case ConnectionMethodConnect:
+			line("connection.resize", oi.ResizeMethod)
+		}
+		line("container.tty", oi.containerConfig.Tty)
+		line("record", oi.Record)
+	}
+	line("users.concurrent", limit(oi.ConcurrentUsers))
+	line("users.total", limit(oi.TotalUsers))
+	line("container.persistBetweenReconnects", oi.PersistBetweenReconnects)
+	line("timeout.session", oi.Timeouts.Total)
+	line("timeout.inactive", oi.Timeouts.Inactive)
+	line("containers.max", limit(oi.limiter.max))
+	line("pool", fmt.Sprintf("min=%d max=%d", oi.Pool.Min, oi.Pool.Max))
+
+	line("scheduler", oi.schedulerName)
+
+	if oi.access.users != nil || oi.access.groups != nil {
+		line("access.users", sortedKeys(oi.access.users))
+		line("access.groups", sortedKeys(oi.access.groups))
+	}
+	if !oi.access.after.IsZero() {
+		line("access.after", oi.access.after.Format(time.RFC3339))
+	}
+	if !oi.access.before.IsZero() {
+		line("access.before", oi.access.before.Format(time.RFC3339))
+	}
+
+	hc := oi.hostConfig
+	if hc.Memory != 0 {
+		line("limits.memory", units.BytesSize(float64(hc.Memory)))
+	}
+	if hc.NanoCPUs != 0 {
+		line("limits.cpus", float64(hc.NanoCPUs)/1e9)
+	}
+	if hc.PidsLimit != nil {
+		line("limits.pids", *hc.PidsLimit)
+	}
+	for _, ulimit := range hc.Ulimits {
+		line("limits.ulimit", ulimit)
+	}
+	if size, found := hc.StorageOpt["size"]; found {
+		line("limits.storage", size)
+	}
+	line("container.readonly", hc.ReadonlyRootfs)
+	mounts := make([]string, 0, len(hc.Tmpfs))
+	for path, opts := range hc.Tmpfs {
+		mounts = append(mounts, strings.TrimSuffix(path+":"+opts, ":"))
+	}
+	sort.Strings(mounts)
+	for _, mount := range mounts {
+		line("container.tmpfs", mount)
+	}
+
+	if len(hc.CapDrop) != 0 {
+		line("security.capdrop", strings.Join(hc.CapDrop, ","))
+		line("security.capadd", strings.Join(hc.CapAdd, ","))
+	}
+	for _, opt := range hc.SecurityOpt {
+		if strings.HasPrefix(opt, "seccomp=") && opt != "seccomp=unconfined" {
+			opt = "seccomp=<custom profile>"
+		}
+		line("security.opt", opt)
+	}
+	if hc.Runtime != "" {
+		line("security.runtime", hc.Runtime)
+	}
+	if hc.UsernsMode != "" {
+		line("security.userns", hc.UsernsMode)
+	}
+	if oi.containerConfig.User != "" {
+		line("security.user", oi.containerConfig.User)
+	}
+
+	line("network.mode", oi.network.mode)
+	if oi.network.isolate {
+		line("network.isolate", true)
+	}
+	switch {
+	case oi.network.denyEgress:
+		line("network.egress", "deny")
+	case oi.network.egressAllow != nil:
+		nets := make([]string, len(oi.network.egressAllow))
+		for n, ipNet := range oi.network.egressAllow {
+			nets[n] = ipNet.String()
+		}
+		line("network.egress", strings.Join(nets, ","))
+	}
+	if len(oi.network.attach) != 0 {
+		line("network.attach", strings.Join(oi.network.attach, ","))
+	}
+	return tw.Flush()
+}
+
+func sortedKeys(m map[string]bool) string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
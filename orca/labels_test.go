@@ -0,0 +1,60 @@
+package orca
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseImage(t *testing.T) {
+	oi, warnings, err := ParseImage(labeledImage(map[string]string{
+		"orca.enabled":          "true",
+		"orca.kind":             "ssh",
+		"orca.name":             "Shell",
+		"orca.timeout.inactive": "5m",
+		"orca.limits.memory":    "64m",
+		"orca.sheduler":         "spread",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oi.Name != "shell" || oi.Timeouts.Inactive != 5*time.Minute || oi.ConcurrentUsers != 1 {
+		t.Errorf("unexpected image %+v", oi)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "orca.sheduler") {
+		t.Errorf("warnings = %v", warnings)
+	}
+
+	var buf bytes.Buffer
+	err = oi.WriteConfig(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"connection.method", "attach", "limits.memory", "64MiB", "scheduler", "pack"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("config has no %s:\n%s", expected, buf.String())
+		}
+	}
+
+	// all errors are reported, not only the first one
+	_, _, err = ParseImage(labeledImage(map[string]string{
+		"orca.kind":             "ssh",
+		"orca.name":             "shell",
+		"orca.timeout.inactive": "15 m",
+		"orca.users.total":      "many",
+		"orca.container.tty":    "yes please",
+	}))
+	labelErrs, ok := err.(LabelErrors)
+	if !ok || len(labelErrs) != 3 {
+		t.Errorf("expected 3 label errors, got %v", err)
+	}
+}
+
+func TestLabelSchemaIsNormalised(t *testing.T) {
+	for key := range labelSchema {
+		if key != strings.ToLower(key) {
+			t.Errorf("%s is not lowercase", key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package orca
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// Resource limits and filesystem options of the containers
+func parseLimits(lp *labelParser, hc *container.HostConfig) {
+	if val, found := lp.Lookup("orca.limits.memory"); found {
+		memory, err := units.RAMInBytes(val)
+		if err != nil || memory <= 0 {
+			lp.Errorf(`orca.limits.memory: invalid size "%s"`, val)
+		} else {
+			hc.Memory = memory
+			// no swap, otherwise the limit is easy to get around
+			hc.MemorySwap = memory
+		}
+	}
+
+	if val, found := lp.Lookup("orca.limits.cpus"); found {
+		cpus, err := strconv.ParseFloat(val, 64)
+		if err != nil || cpus <= 0 {
+			lp.Errorf(`orca.limits.cpus: invalid number of cpus "%s"`, val)
+		} else {
+			hc.NanoCPUs = int64(cpus * 1e9)
+		}
+	}
+
+	// docker treats 0 as unlimited, that's not a limit
+	if pids, found := lp.PositiveInt("orca.limits.pids"); found {
+		pidsLimit := int64(pids)
+		hc.PidsLimit = &pidsLimit
+	}
+
+	// "nofile=1024:2048,nproc=512"
+	if val, found := lp.Lookup("orca.limits.ulimits"); found {
+		for _, item := range strings.Split(val, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			ulimit, err := units.ParseUlimit(item)
+			if err != nil {
+				lp.Errorf("orca.limits.ulimits: %s", err)
+				continue
+			}
+			hc.Ulimits = append(hc.Ulimits, ulimit)
+		}
+	}
+
+	// supported only by some storage drivers (e.g. overlay2 on xfs with pquota)
+	if val, found := lp.Lookup("orca.limits.storage"); found {
+		size, err := units.RAMInBytes(val)
+		if err != nil || size <= 0 {
+			lp.Errorf(`orca.limits.storage: invalid size "%s"`, val)
+		} else {
+			hc.StorageOpt = map[string]string{"size": val}
+		}
+	}
+
+	hc.ReadonlyRootfs = lp.Bool("orca.container.readonly", false)
+
+	// "/tmp:size=64m,noexec;/run"
+	if val, found := lp.Lookup("orca.container.tmpfs"); found {
+		hc.Tmpfs = make(map[string]string)
+		for _, mount := range strings.Split(val, ";") {
+			mount = strings.TrimSpace(mount)
+			if mount == "" {
+				continue
+			}
+			path, opts := mount, ""
+			if i := strings.IndexByte(mount, ':'); i != -1 {
+				path, opts = mount[:i], mount[i+1:]
+			}
+			if !strings.HasPrefix(path, "/") {
+				lp.Errorf(`orca.container.tmpfs: mount point "%s" is not absolute`, path)
+				continue
+			}
+			hc.Tmpfs[path] = opts
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package orca
+
+import (
+	"testing"
+
+	"github.com/Andrew-Morozko/orca/orca/mydocker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func labeledImage(labels map[string]string) *mydocker.Image {
+	return &mydocker.Image{ImageInspect: &types.ImageInspect{
+		Config: &container.Config{Labels: labels},
+	}}
+}
+
+func TestParseLimits(t *testing.T) {
+	var hc container.HostConfig
+	lp := newLabelParser(labeledImage(map[string]string{
+		"orca.limits.memory":      "256m",
+		"orca.limits.cpus":        "0.5",
+		"orca.limits.pids":        "100",
+		"orca.limits.ulimits":     "nofile=1024:2048, nproc=64",
+		"orca.container.readonly": "true",
+		"orca.container.tmpfs":    "/tmp:size=64m,noexec; /run",
+	}))
+	parseLimits(lp, &hc)
+	if err := lp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if hc.Memory != 256*1024*1024 || hc.MemorySwap != hc.Memory {
+		t.Errorf("memory = %d, swap = %d", hc.Memory, hc.MemorySwap)
+	}
+	if hc.NanoCPUs != 5e8 {
+		t.Errorf("nanocpus = %d", hc.NanoCPUs)
+	}
+	if hc.PidsLimit == nil || *hc.PidsLimit != 100 {
+		t.Errorf("pids = %v", hc.PidsLimit)
+	}
+	if len(hc.Ulimits) != 2 || hc.Ulimits[0].Name != "nofile" || hc.Ulimits[0].Hard != 2048 {
+		t.Errorf("ulimits = %v", hc.Ulimits)
+	}
+	if !hc.ReadonlyRootfs {
+		t.Error("rootfs is not readonly")
+	}
+	if len(hc.Tmpfs) != 2 || hc.Tmpfs["/tmp"] != "size=64m,noexec" {
+		t.Errorf("tmpfs = %v", hc.Tmpfs)
+	}
+
+	for _, labels := range []map[string]string{
+		{"orca.limits.memory": "lots"},
+		{"orca.limits.cpus": "-1"},
+		{"orca.limits.pids": "1.5"},
+		{"orca.limits.pids": "0"},
+		{"orca.limits.ulimits": "nofile"},
+		{"orca.container.readonly": "maybe"},
+		{"orca.container.tmpfs": "tmp"},
+	} {
+		lp := newLabelParser(labeledImage(labels))
+		parseLimits(lp, &container.HostConfig{})
+		if lp.Err() == nil {
+			t.Errorf("%v: no error", labels)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Prometheus metrics of orca, served on /metrics
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Docker is slow, so the default buckets (up to 10s) are too short
+var slowBuckets = prometheus.ExponentialBuckets(0.05, 2, 12) // 50ms - ~100s
+
+// Containers
+var (
+	ContainersLaunched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orca_containers_launched_total",
+		Help: "Containers successfully launched.",
+	}, []string{"image"})
+	ContainersFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orca_containers_failed_total",
+		Help: "Container launches that failed.",
+	}, []string{"image"})
+	ContainersRemoved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orca_containers_removed_total",
+		Help: "Containers removed at the end of their life.",
+	}, []string{"image"})
+	LaunchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "orca_container_launch_duration_seconds",
+		Help:    "Time to create and start a container, including the network setup.",
+		Buckets: slowBuckets,
+	}, []string{"image"})
+	ConcurrentUsers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orca_concurrent_users",
+		Help: "Users currently assigned to the containers.",
+	}, []string{"image"})
+)
+
+// Elections of the container with a free spot
+var (
+	Elections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orca_elections_total",
+		Help: `Elections held, outcome is "candidate" if an existing container was picked, "none" otherwise.`,
+	}, []string{"image", "outcome"})
+	ElectionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "orca_election_duration_seconds",
+		Help:    "Time from the election request to the decision, including the wait for the previous election.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 12), // 1ms - ~2s
+	}, []string{"image"})
+)
+
+// Sessions of the users (lifetimes of ContainerUsers), kind is the image kind
+var (
+	Sessions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orca_sessions_total",
+		Help: "User sessions started.",
+	}, []string{"kind", "image"})
+	SessionsActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orca_sessions_active",
+		Help: "User sessions in progress.",
+	}, []string{"kind", "image"})
+	SessionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "orca_session_duration_seconds",
+		Help:    "Duration of the user sessions.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10), // 1s - ~3 days
+	}, []string{"kind"})
+	// connections to the orca itself, including the ones that never got a container
+	Connections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orca_connections_total",
+		Help: "Connections accepted by the ssh, tcp and http servers.",
+	}, []string{"kind"})
+	ConnectionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "orca_connection_duration_seconds",
+		Help:    "Duration of the ssh and tcp connections and of the http requests.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 12), // 10ms - ~12h
+	}, []string{"kind"})
+	ProxiedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orca_proxied_bytes_total",
+		Help: `Bytes copied between the users and the containers, direction is "in" (to the container) or "out".`,
+	}, []string{"kind", "direction"})
+)
+
+// Authentication, method is "password", "publickey" or "token"
+var (
+	AuthDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "orca_auth_duration_seconds",
+		Help:    "Latency of the calls to the auth backend.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12), // 5ms - ~10s
+	}, []string{"method"})
+	AuthFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orca_auth_failures_total",
+		Help: `Failed authentications, reason is "denied" (wrong credentials) or "error" (backend failed).`,
+	}, []string{"method", "reason"})
+)
+
+// Reports the count of the log messages lost to the buffer overflow
+func RegisterDroppedLogs(dropped func() uint64) {
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "orca_log_messages_dropped_total",
+		Help: "Log messages thrown away because the log buffer was full.",
+	}, func() float64 {
+		return float64(dropped())
+	})
+}
+
+// Seconds since start, for the histograms
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
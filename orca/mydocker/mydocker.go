@@ -1,14 +1,12 @@
 package mydocker
 
 import (
-	"log"
-	"os"
-	"strconv"
+	"context"
 	"strings"
-	"time"
 
 	"github.com/Andrew-Morozko/orca/jobcontroller"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 )
@@ -18,8 +16,9 @@ type Client struct {
 	// t string
 }
 
-func FromEnv() (myclient *Client, err error) {
-	cl, err := client.NewClientWithOpts(client.FromEnv, client.WithVersion(os.Getenv("ORCA_DOCKER_VERSION")))
+// version is the api version, empty to use the default
+func FromEnv(version string) (myclient *Client, err error) {
+	cl, err := client.NewClientWithOpts(client.FromEnv, client.WithVersion(version))
 	if err != nil {
 		return nil, err
 	}
@@ -44,7 +43,8 @@ func wrapImage(img *types.ImageInspect) *Image {
 	}
 }
 
-func (c *Client) ListLabeledImages(jc jobcontroller.JobController) ([]*Image, error) {
+// IDs of images that could be served by orca
+func (c *Client) ListLabeledImageIDs(jc jobcontroller.JobController) ([]string, error) {
 	images, err := c.ImageList(jc, types.ImageListOptions{
 		All: false,
 		Filters: filters.NewArgs(
@@ -56,21 +56,67 @@ func (c *Client) ListLabeledImages(jc jobcontroller.JobController) ([]*Image, er
 		return nil, err
 	}
 
-	res := make([]*Image, 0, len(images))
+	res := make([]string, 0, len(images))
 	for _, imgSum := range images {
-		imgDetails, _, err := c.ImageInspectWithRaw(jc, imgSum.ID)
-		if err != nil {
-			jc.Logger.Errf(err,
-				"failed to inspect image %s",
-				strings.Split(imgSum.ID, ":")[1][:8],
-			)
-			continue
-		}
-		res = append(res, wrapImage(&imgDetails))
+		res = append(res, imgSum.ID)
 	}
 	return res, nil
 }
 
+// id could be any image reference
+func (c *Client) InspectImage(ctx context.Context, id string) (*Image, error) {
+	imgDetails, _, err := c.ImageInspectWithRaw(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return wrapImage(&imgDetails), nil
+}
+
+// Stream of events that could change the list of labeled images
+func (c *Client) ImageEvents(ctx context.Context) (<-chan events.Message, <-chan error) {
+	return c.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", events.ImageEventType),
+			filters.Arg("event", "tag"),
+			filters.Arg("event", "untag"),
+			filters.Arg("event", "delete"),
+			filters.Arg("event", "pull"),
+			filters.Arg("event", "import"),
+			filters.Arg("event", "load"),
+		),
+	})
+}
+
+// Containers created by orca, including stopped ones
+func (c *Client) ListManagedContainers(jc jobcontroller.JobController) ([]types.Container, error) {
+	return c.ContainerList(jc, types.ContainerListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", "orca.internal.managed=true"),
+		),
+	})
+}
+
+// Networks created by orca
+func (c *Client) ListManagedNetworks(jc jobcontroller.JobController) ([]types.NetworkResource, error) {
+	return c.NetworkList(jc, types.NetworkListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", "orca.internal.managed=true"),
+		),
+	})
+}
+
+// First 8 chars of the hash of sha256:... docker id
+func ShortID(id string) string {
+	if i := strings.IndexByte(id, ':'); i != -1 {
+		id = id[i+1:]
+	}
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return id
+}
+
 /////////////////////////////////////
 
 func Normalise(s string) string {
@@ -85,51 +131,11 @@ func (di *Image) Get(key string) (string, bool) {
 	return val, found
 }
 
-func (di *Image) GetDefault(key string, defaultVal string) string {
-	val, found := di.Get(key)
-	if !found {
-		val = defaultVal
-	}
-	return val
-}
-
-func (di *Image) GetIntDefault(key string, defaultVal int) int {
-	val, found := di.Get(key)
-	if found {
-		res, err := strconv.Atoi(val)
-		if err != nil {
-			log.Printf(`["%s": "%s"] int parse error: %s`+"\n", key, val, err)
-			return defaultVal
-		}
-		return res
-	} else {
-		return defaultVal
-	}
-}
-func (di *Image) GetDurationDefault(key string, defaultVal time.Duration) time.Duration {
-	val, found := di.Get(key)
-	if found {
-		res, err := time.ParseDuration(val)
-		if err != nil {
-			log.Printf(`["%s": "%s"] duration parse error: %s`+"\n", key, val, err)
-			return defaultVal
-		}
-		return res
-	} else {
-		return defaultVal
-	}
-}
-
-func (di *Image) GetBoolDefault(key string, defaultVal bool) bool {
-	val, found := di.Get(key)
+// Same as Get, but the value is only trimmed, not lowercased
+func (di *Image) GetRaw(key string) (string, bool) {
+	val, found := di.Config.Labels[Normalise(key)]
 	if found {
-		res, err := strconv.ParseBool(val)
-		if err != nil {
-			log.Printf(`["%s": "%s"] bool parse error: %s`+"\n", key, val, err)
-			return defaultVal
-		}
-		return res
-	} else {
-		return defaultVal
+		val = strings.TrimSpace(val)
 	}
+	return val, found
 }
@@ -0,0 +1,310 @@
+package orca
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/Andrew-Morozko/orca/config"
+	"github.com/Andrew-Morozko/orca/jobcontroller"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/pkg/errors"
+)
+
+type NetworkMode = string
+
+const (
+	// Docker's default bridge
+	NetworkModeBridge NetworkMode = "bridge"
+	// Orca-managed network shared by the containers of the image
+	NetworkModeImage NetworkMode = "image"
+	// Orca-managed network for every container
+	NetworkModeContainer NetworkMode = "container"
+	NetworkModeNone      NetworkMode = "none"
+)
+
+// If orca itself runs in a container, it has to join the managed
+// networks to reach the containers
+func selfContainer() string {
+	return config.Get().Containers.SelfContainer
+}
+
+const bridgeNameOption = "com.docker.network.bridge.name"
+
+type networkPolicy struct {
+	mode NetworkMode
+	// containers on the managed network can't reach each other
+	isolate bool
+	// no internet access at all
+	denyEgress bool
+	// if not nil, internet access is allowed only to these networks
+	egressAllow []*net.IPNet
+	// existing networks the containers are connected to
+	attach []string
+}
+
+func (np *networkPolicy) isManaged() bool {
+	return np.mode == NetworkModeImage || np.mode == NetworkModeContainer
+}
+
+func parseNetworkPolicy(lp *labelParser, cc *container.Config, hc *container.HostConfig) (np networkPolicy) {
+	mode, found := lp.Lookup("orca.network.mode")
+	if !found {
+		// orca.container.networkdisabled or docker's default
+		np.mode = NetworkModeBridge
+		if cc.NetworkDisabled {
+			np.mode = NetworkModeNone
+		}
+	} else {
+		np.mode = mode
+	}
+	switch np.mode {
+	case NetworkModeBridge, NetworkModeImage, NetworkModeContainer:
+		cc.NetworkDisabled = false
+	case NetworkModeNone:
+		cc.NetworkDisabled = true
+		hc.NetworkMode = "none"
+	default:
+		lp.Errorf(`orca.network.mode: unknown network mode "%s"`, np.mode)
+	}
+
+	np.isolate = lp.Bool("orca.network.isolate", false)
+	if np.isolate && np.mode != NetworkModeImage {
+		lp.Errorf(`orca.network.isolate requires the "image" network mode`)
+	}
+
+	if val, found := lp.Lookup("orca.network.egress"); found {
+		if !np.isManaged() {
+			lp.Errorf(`orca.network.egress requires the "image" or "container" network mode`)
+		}
+		switch val {
+		case "allow":
+		case "deny":
+			np.denyEgress = true
+		default:
+			// allowlist
+			np.egressAllow = []*net.IPNet{}
+			for _, item := range strings.Split(val, ",") {
+				item = strings.TrimSpace(item)
+				if item == "" {
+					continue
+				}
+				if !strings.Contains(item, "/") {
+					item += "/32"
+				}
+				_, ipNet, err := net.ParseCIDR(item)
+				if err != nil {
+					lp.Errorf("orca.network.egress: %s", err)
+					continue
+				}
+				np.egressAllow = append(np.egressAllow, ipNet)
+			}
+		}
+	}
+
+	if val, found := lp.Lookup("orca.network.attach"); found {
+		if np.mode == NetworkModeNone {
+			lp.Errorf(`orca.network.attach: can't attach networks in the "none" network mode`)
+		}
+		for _, name := range strings.Split(val, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				np.attach = append(np.attach, name)
+			}
+		}
+	}
+	return np
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// docker allows only [a-zA-Z0-9][a-zA-Z0-9_.-] in network names, image names have "/" and ":"
+var networkNameRe = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// Prefix of the managed network names of the image, kind is "" for the
+// image network and "c-" for the per container ones
+func networkNamePrefix(imageName, kind string) string {
+	return "orca-" + networkNameRe.ReplaceAllString(imageName, "_") + "-" + kind
+}
+
+// Random part of the name is 4 bytes in hex
+func isManagedNetworkName(name, prefix string) bool {
+	return strings.HasPrefix(name, prefix) && len(name) == len(prefix)+8
+}
+
+// Name of the managed network for the new container, created if needed.
+// Empty if the container uses docker's default. owned networks are removed
+// together with the container.
+func (oi *Image) containerNetwork(jc jobcontroller.JobController) (name string, owned bool, err error) {
+	switch oi.network.mode {
+	case NetworkModeImage:
+		oi.networkLock.Lock()
+		defer oi.networkLock.Unlock()
+		if oi.networkName == "" {
+			name = networkNamePrefix(oi.Name, "") + randomHex(4)
+			err = oi.createNetwork(jc, name)
+			if err != nil {
+				return "", false, err
+			}
+			oi.networkName = name
+		}
+		return oi.networkName, false, nil
+	case NetworkModeContainer:
+		name = networkNamePrefix(oi.Name, "c-") + randomHex(4)
+		err = oi.createNetwork(jc, name)
+		if err != nil {
+			return "", false, err
+		}
+		return name, true, nil
+	}
+	return "", false, nil
+}
+
+// Managed network of the container left over from the previous run.
+// It's recorded like the networks of this run, so it's removed in the end.
+func (oi *Image) adoptNetwork(jc jobcontroller.JobController, dockerId string) (name string, owned bool, err error) {
+	if !oi.network.isManaged() {
+		return "", false, nil
+	}
+	res, err := Docker.ContainerInspect(jc, dockerId)
+	if err != nil {
+		return "", false, err
+	}
+	for name := range res.NetworkSettings.Networks {
+		switch {
+		case oi.network.mode == NetworkModeContainer && isManagedNetworkName(name, networkNamePrefix(oi.Name, "c-")):
+			return name, true, nil
+		case oi.network.mode == NetworkModeImage && isManagedNetworkName(name, networkNamePrefix(oi.Name, "")):
+			oi.networkLock.Lock()
+			defer oi.networkLock.Unlock()
+			if oi.networkName == "" {
+				oi.networkName = name
+			}
+			// image already has another network, this one goes with the container
+			return name, oi.networkName != name, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (oi *Image) createNetwork(jc jobcontroller.JobController, name string) (err error) {
+	bridge := "orca" + randomHex(4)
+	options := map[string]string{
+		bridgeNameOption: bridge,
+	}
+	if oi.network.isolate {
+		options["com.docker.network.bridge.enable_icc"] = "false"
+	}
+	_, err = Docker.NetworkCreate(jc, name, types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Internal:       oi.network.denyEgress,
+		Options:        options,
+		Labels: map[string]string{
+			"orca.internal.managed":   "true",
+			"orca.internal.imagename": oi.Name,
+		},
+	})
+	if err != nil {
+		return errors.WithMessage(err, "creating network")
+	}
+	defer func() {
+		if err != nil {
+			err2 := removeNetwork(jc.CleanupCtx, name)
+			jc.Logger.Err(err2, "failed to remove network after failed setup")
+		}
+	}()
+
+	if oi.network.egressAllow != nil {
+		err = setEgressRules(bridge, oi.network.egressAllow, true)
+		if err != nil {
+			return err
+		}
+	}
+	if self := selfContainer(); self != "" {
+		err = Docker.NetworkConnect(jc, name, self, nil)
+		if err != nil {
+			return errors.WithMessage(err, "connecting orca to the network")
+		}
+	}
+	jc.Logger.Logf("Created network %s", name)
+	return nil
+}
+
+// Removes managed network together with its egress rules
+func removeNetwork(ctx context.Context, name string) error {
+	res, err := Docker.NetworkInspect(ctx, name, types.NetworkInspectOptions{})
+	if err != nil {
+		return err
+	}
+	if self := selfContainer(); self != "" {
+		_ = Docker.NetworkDisconnect(ctx, name, self, true)
+	}
+	err = Docker.NetworkRemove(ctx, name)
+	if err != nil {
+		return err
+	}
+	if bridge := res.Options[bridgeNameOption]; bridge != "" {
+		// rules may be absent, that's fine
+		_ = setEgressRules(bridge, nil, false)
+	}
+	return nil
+}
+
+// Image network is removed once the image is retired
+func (oi *Image) removeNetwork(jc jobcontroller.JobController) {
+	oi.networkLock.Lock()
+	name := oi.networkName
+	oi.networkName = ""
+	oi.networkLock.Unlock()
+	if name == "" {
+		return
+	}
+	err := removeNetwork(jc.CleanupCtx, name)
+	jc.Logger.Err(err, "failed to remove network ", name)
+}
+
+// Traffic forwarded from the bridge is dropped unless it's going to the allowed networks.
+// Docker evaluates the DOCKER-USER chain before its own rules.
+func setEgressRules(bridge string, allow []*net.IPNet, add bool) error {
+	if !add {
+		// delete every rule mentioning the bridge
+		out, err := exec.Command("iptables", "-w", "-S", "DOCKER-USER").Output()
+		if err != nil {
+			return errors.WithMessage(err, "listing iptables rules")
+		}
+		for _, rule := range strings.Split(string(out), "\n") {
+			fields := strings.Fields(rule)
+			if len(fields) < 2 || fields[0] != "-A" || !strings.Contains(rule, "-i "+bridge+" ") {
+				continue
+			}
+			args := append([]string{"-w", "-D"}, fields[1:]...)
+			_ = exec.Command("iptables", args...).Run()
+		}
+		return nil
+	}
+
+	// rules are inserted at the top, so the drop goes first
+	rules := [][]string{{"-i", bridge, "-j", "DROP"}}
+	for _, ipNet := range allow {
+		rules = append(rules, []string{"-i", bridge, "-d", ipNet.String(), "-j", "RETURN"})
+	}
+	for _, rule := range rules {
+		args := append([]string{"-w", "-I", "DOCKER-USER"}, rule...)
+		out, err := exec.Command("iptables", args...).CombinedOutput()
+		if err != nil {
+			_ = setEgressRules(bridge, nil, false)
+			return errors.Errorf("iptables %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package orca
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestParseNetworkPolicy(t *testing.T) {
+	cc := container.Config{NetworkDisabled: true}
+	var hc container.HostConfig
+	lp := newLabelParser(labeledImage(map[string]string{
+		"orca.network.mode":    "image",
+		"orca.network.isolate": "true",
+		"orca.network.egress":  "10.0.0.0/8, 1.1.1.1",
+		"orca.network.attach":  "db, cache",
+	}))
+	np := parseNetworkPolicy(lp, &cc, &hc)
+	if err := lp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if cc.NetworkDisabled || !np.isolate || np.denyEgress {
+		t.Errorf("unexpected policy %+v", np)
+	}
+	if len(np.egressAllow) != 2 || np.egressAllow[1].String() != "1.1.1.1/32" {
+		t.Errorf("egress = %v", np.egressAllow)
+	}
+	if len(np.attach) != 2 || np.attach[1] != "cache" {
+		t.Errorf("attach = %v", np.attach)
+	}
+
+	// networkdisabled is respected if the mode isn't set
+	cc = container.Config{NetworkDisabled: true}
+	np = parseNetworkPolicy(newLabelParser(labeledImage(nil)), &cc, &hc)
+	if np.mode != NetworkModeNone {
+		t.Errorf("mode = %s", np.mode)
+	}
+
+	for _, labels := range []map[string]string{
+		{"orca.network.mode": "host"},
+		{"orca.network.isolate": "true"},
+		{"orca.network.egress": "deny"},
+		{"orca.network.mode": "container", "orca.network.egress": "internet"},
+		{"orca.network.mode": "none", "orca.network.attach": "db"},
+	} {
+		lp := newLabelParser(labeledImage(labels))
+		parseNetworkPolicy(lp, &container.Config{}, &container.HostConfig{})
+		if lp.Err() == nil {
+			t.Errorf("%v: no error", labels)
+		}
+	}
+}
+
+func TestNetworkName(t *testing.T) {
+	prefix := networkNamePrefix("myorg/task:latest", "c-")
+	if prefix != "orca-myorg_task_latest-c-" {
+		t.Errorf("prefix = %s", prefix)
+	}
+	name := prefix + randomHex(4)
+	if !isManagedNetworkName(name, prefix) {
+		t.Errorf("%s is not managed", name)
+	}
+	// per container networks don't belong to the image
+	if isManagedNetworkName(name, networkNamePrefix("myorg/task:latest", "")) {
+		t.Errorf("%s is the image network", name)
+	}
+}
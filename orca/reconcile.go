@@ -0,0 +1,132 @@
+package orca
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Andrew-Morozko/orca/jobcontroller"
+	"github.com/Andrew-Morozko/orca/orca/mydocker"
+	"github.com/docker/docker/api/types"
+)
+
+var gcInterval = 5 * time.Minute
+
+// Finds the orca-managed containers that are not tracked by any image
+// (left over after a crash or failed removal) and removes them.
+// If adopt is set, running orphans of known images are taken over instead,
+// if the image allows it.
+func (il *ImageList) reconcileContainers(jc jobcontroller.JobController, adopt bool) error {
+	containers, err := Docker.ListManagedContainers(jc)
+	if err != nil {
+		return err
+	}
+
+	il.lock.Lock()
+	images := make([]*Image, 0, len(il.imagesByDockerID)+len(il.removedImages))
+	for _, img := range il.imagesByDockerID {
+		images = append(images, img)
+	}
+	for _, img := range il.removedImages {
+		images = append(images, img)
+	}
+	il.lock.Unlock()
+
+	isTracked := func(id, launchID string) bool {
+		for _, img := range images {
+			if img.hasContainer(id, launchID) {
+				return true
+			}
+		}
+		return false
+	}
+
+	adopted, removed := 0, 0
+	for _, c := range containers {
+		// launches in flight aren't registered yet
+		if isTracked(c.ID, c.Labels["orca.internal.launch"]) {
+			continue
+		}
+
+		if adopt && c.State == "running" {
+			il.lock.Lock()
+			img := il.imagesByDockerID[c.ImageID]
+			il.lock.Unlock()
+			if img != nil && img.Name == c.Labels["orca.internal.imagename"] && img.canAdoptContainers() {
+				_, err := img.adoptContainer(jc, c.ID)
+				if err == nil {
+					jc.Logger.Logf("Adopted container %s of %s", mydocker.ShortID(c.ID), img)
+					adopted++
+					continue
+				}
+				jc.Logger.Warn.Errf(err, "failed to adopt container %s", mydocker.ShortID(c.ID))
+			}
+		}
+
+		err := Docker.ContainerRemove(jc, c.ID, types.ContainerRemoveOptions{Force: true})
+		if err != nil {
+			jc.Logger.Warn.Errf(err, "failed to remove orphaned container %s", mydocker.ShortID(c.ID))
+			continue
+		}
+		jc.Logger.Logf("Removed orphaned container %s (%s)", mydocker.ShortID(c.ID), c.Labels["orca.internal.imagename"])
+		removed++
+	}
+	if adopted != 0 || removed != 0 {
+		jc.Logger.Logf("%d orphaned containers adopted, %d removed", adopted, removed)
+	}
+	if adopt {
+		il.removeOrphanedNetworks(jc)
+	}
+	return nil
+}
+
+// Removes unused managed networks left over from the previous run.
+// Networks in use by the running images are only created after startup.
+func (il *ImageList) removeOrphanedNetworks(jc jobcontroller.JobController) {
+	networks, err := Docker.ListManagedNetworks(jc)
+	if err != nil {
+		jc.Logger.Warn.Err(err, "failed to list networks")
+		return
+	}
+	for _, n := range networks {
+		if !n.Created.Before(il.createdAt) {
+			continue
+		}
+		inUse := false
+		self := selfContainer()
+		for id, endpoint := range n.Containers {
+			if self == "" || (!strings.HasPrefix(id, self) && endpoint.Name != self) {
+				inUse = true
+				break
+			}
+		}
+		if inUse {
+			continue
+		}
+		err = removeNetwork(jc, n.ID)
+		if err != nil {
+			jc.Logger.Warn.Errf(err, "failed to remove orphaned network %s", n.Name)
+			continue
+		}
+		jc.Logger.Logf("Removed orphaned network %s", n.Name)
+	}
+}
+
+// Periodically removes the containers that slipped through
+func (il *ImageList) collectGarbage(jc jobcontroller.JobController) {
+	defer jc.Job.Done()
+	jc = jc.AddLoggerPrefix("Container GC")
+
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := il.reconcileContainers(jc, false)
+			jc.Logger.Warn.Err(err, "garbage collection failed")
+		case <-jc.ShutdownRequested():
+			return
+		case <-jc.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package orca
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Picks the container for the new user out of the election candidates
+type scheduler interface {
+	// Index of the chosen candidate, candidates are never empty
+	Pick(candidates []*contatinerCandidate) int
+	// Containers have to sample their cpu usage for this scheduler
+	NeedsCPUStats() bool
+}
+
+type schedulerFunc struct {
+	pick     func(candidates []*contatinerCandidate) int
+	needsCPU bool
+}
+
+func (sf schedulerFunc) Pick(candidates []*contatinerCandidate) int {
+	return sf.pick(candidates)
+}
+func (sf schedulerFunc) NeedsCPUStats() bool {
+	return sf.needsCPU
+}
+
+// Index of the smallest candidate according to less
+func minCandidate(candidates []*contatinerCandidate, less func(a, b *contatinerCandidate) bool) int {
+	best := 0
+	for n, candidate := range candidates {
+		if less(candidate, candidates[best]) {
+			best = n
+		}
+	}
+	return best
+}
+
+// a could serve more users before the end of its life than b, -1 is unlimited
+func outlives(a, b *contatinerCandidate) bool {
+	if a.remainingUsers == b.remainingUsers || b.remainingUsers < 0 {
+		return false
+	}
+	return a.remainingUsers < 0 || a.remainingUsers > b.remainingUsers
+}
+
+var schedulers = map[string]scheduler{
+	// Packs users into as little containers as possible,
+	// the ones that aren't about to reach the end of life first
+	"pack": schedulerFunc{pick: func(candidates []*contatinerCandidate) int {
+		return minCandidate(candidates, func(a, b *contatinerCandidate) bool {
+			if a.concurrentUsers != b.concurrentUsers {
+				return a.concurrentUsers > b.concurrentUsers
+			}
+			return outlives(a, b)
+		})
+	}},
+	// Spreads users equally over the existing containers, the ones with
+	// more life left first, then older containers
+	"spread": schedulerFunc{pick: func(candidates []*contatinerCandidate) int {
+		return minCandidate(candidates, func(a, b *contatinerCandidate) bool {
+			if a.concurrentUsers != b.concurrentUsers {
+				return a.concurrentUsers < b.concurrentUsers
+			}
+			if a.remainingUsers != b.remainingUsers {
+				return outlives(a, b)
+			}
+			return a.startedAt.Before(b.startedAt)
+		})
+	}},
+	// Prefers the containers that have served the least users over their lifetime
+	"least-total-users": schedulerFunc{pick: func(candidates []*contatinerCandidate) int {
+		return minCandidate(candidates, func(a, b *contatinerCandidate) bool {
+			if a.totalUsers != b.totalUsers {
+				return a.totalUsers < b.totalUsers
+			}
+			return a.concurrentUsers < b.concurrentUsers
+		})
+	}},
+	"random": schedulerFunc{pick: func(candidates []*contatinerCandidate) int {
+		return rand.Intn(len(candidates))
+	}},
+	// Prefers the least loaded containers
+	"least-cpu": schedulerFunc{needsCPU: true, pick: func(candidates []*contatinerCandidate) int {
+		return minCandidate(candidates, func(a, b *contatinerCandidate) bool {
+			if a.cpuUsage != b.cpuUsage {
+				return a.cpuUsage < b.cpuUsage
+			}
+			return a.concurrentUsers < b.concurrentUsers
+		})
+	}},
+}
+
+const defaultScheduler = "pack"
+
+var cpuSampleInterval = 10 * time.Second
+
+// Sends cpu usage of the container (percents of a single cpu) until ctx is done
+func (oc *Container) sampleCPU(ctx context.Context, usageC chan<- float64) {
+	ticker := time.NewTicker(cpuSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		usage, err := oc.cpuUsage(ctx)
+		if err != nil {
+			continue
+		}
+		select {
+		case usageC <- usage:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (oc *Container) cpuUsage(ctx context.Context) (float64, error) {
+	// non-streaming stats have precpu_stats filled in
+	res, err := Docker.ContainerStats(ctx, oc.DockerID, false)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	var stats types.StatsJSON
+	err = json.NewDecoder(res.Body).Decode(&stats)
+	if err != nil {
+		return 0, err
+	}
+
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0, nil
+	}
+	return cpuDelta / systemDelta * cpus * 100, nil
+}
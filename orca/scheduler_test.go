@@ -0,0 +1,53 @@
+package orca
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulers(t *testing.T) {
+	now := time.Now()
+	candidates := []*contatinerCandidate{
+		{concurrentUsers: 1, totalUsers: 5, startedAt: now, cpuUsage: 10},
+		{concurrentUsers: 3, totalUsers: 3, startedAt: now.Add(-time.Hour), cpuUsage: 50},
+		{concurrentUsers: 1, totalUsers: 2, startedAt: now.Add(-time.Minute), cpuUsage: 5},
+	}
+	expected := map[string]int{
+		"pack":              1,
+		"spread":            2,
+		"least-total-users": 2,
+		"least-cpu":         2,
+	}
+	for name, idx := range expected {
+		if res := schedulers[name].Pick(candidates); res != idx {
+			t.Errorf("%s picked %d, expected %d", name, res, idx)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		if res := schedulers["random"].Pick(candidates); res < 0 || res >= len(candidates) {
+			t.Fatalf("random picked %d", res)
+		}
+	}
+	if !schedulers["least-cpu"].NeedsCPUStats() || schedulers["pack"].NeedsCPUStats() {
+		t.Error("only least-cpu needs cpu stats")
+	}
+
+	// equally loaded, the container on its last user goes last
+	candidates = []*contatinerCandidate{
+		{concurrentUsers: 1, remainingUsers: 1, startedAt: now.Add(-time.Hour)},
+		{concurrentUsers: 1, remainingUsers: 4, startedAt: now},
+		{concurrentUsers: 1, remainingUsers: -1, startedAt: now.Add(-time.Minute)},
+	}
+	for _, name := range []string{"pack", "spread"} {
+		if res := schedulers[name].Pick(candidates); res != 2 {
+			t.Errorf("%s picked %d, expected the unlimited one", name, res)
+		}
+	}
+	candidates = candidates[:2]
+	for _, name := range []string{"pack", "spread"} {
+		if res := schedulers[name].Pick(candidates); res != 1 {
+			t.Errorf("%s picked %d, expected the one with more users left", name, res)
+		}
+	}
+}
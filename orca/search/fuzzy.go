@@ -0,0 +1,56 @@
+package trie
+
+import "sort"
+
+// Number of single rune insertions, deletions and substitutions turning a into b
+func Levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// previous and current rows of the distance matrix
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(min(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// Words within the typo distance of word (about a third of its length),
+// closest first, at most limit of them
+func Similar(word string, words []string, limit int) []string {
+	maxDist := len([]rune(word)) / 3
+	if maxDist < 1 {
+		maxDist = 1
+	}
+	type candidate struct {
+		word string
+		dist int
+	}
+	var candidates []candidate
+	for _, w := range words {
+		if dist := Levenshtein(word, w); dist <= maxDist {
+			candidates = append(candidates, candidate{w, dist})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].dist < candidates[j].dist
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	res := make([]string, len(candidates))
+	for n, c := range candidates {
+		res[n] = c.word
+	}
+	return res
+}
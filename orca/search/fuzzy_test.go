@@ -0,0 +1,37 @@
+package trie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		dist int
+	}{
+		{"", "", 0},
+		{"pwn", "", 3},
+		{"pwn1", "pwn1", 0},
+		{"pwn1", "pnw1", 2},
+		{"kitten", "sitting", 3},
+		{"задача", "задачи", 1},
+	} {
+		if dist := Levenshtein(tc.a, tc.b); dist != tc.dist {
+			t.Errorf("Levenshtein(%q, %q) = %d, expected %d", tc.a, tc.b, dist, tc.dist)
+		}
+	}
+}
+
+func TestSimilar(t *testing.T) {
+	words := []string{"crypto1", "crypto2", "pwn1", "web", "reverse"}
+	if res := Similar("crypt1", words, 3); !reflect.DeepEqual(res, []string{"crypto1", "crypto2"}) {
+		t.Errorf("got %v", res)
+	}
+	if res := Similar("revrese", words, 3); !reflect.DeepEqual(res, []string{"reverse"}) {
+		t.Errorf("got %v", res)
+	}
+	if res := Similar("forensics", words, 3); len(res) != 0 {
+		t.Errorf("got %v", res)
+	}
+}
@@ -2,18 +2,38 @@ package trie
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"unicode"
 )
 
 type Trie struct {
-	root   *Node
-	length int
+	root     *Node
+	length   int
+	foldCase bool
 }
 type Node struct {
-	prefix         string
+	// runes of the key, folded if the trie is case insensitive
+	prefix         []rune
 	parent         *Node
-	children       map[byte]*Node
+	children       map[rune]*Node
 	isCompleteWord bool
+	// as it was added
+	word  string
+	value interface{}
+}
+
+// Word and its payload
+type Entry struct {
+	Word  string
+	Value interface{}
+}
+
+type Option func(*Trie)
+
+// Case insensitive trie: words and queries are compared case folded
+func FoldCase(t *Trie) {
+	t.foldCase = true
 }
 
 func min(a, b int) int {
@@ -31,73 +51,126 @@ func (n *Node) getChild() (child *Node) {
 	return
 }
 
-func (n *Node) replaceChild(orig, replacement *Node) {
-	n.children[orig.prefix[0]] = replacement
+// Children in the order of their keys
+func (n *Node) sortedChildren() []*Node {
+	children := make([]*Node, 0, len(n.children))
+	for _, child := range n.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].prefix[0] < children[j].prefix[0]
+	})
+	return children
 }
 
-func (t *Trie) Search(str string) (guaranteedContinuation string, isExactMatch bool) {
-	match, unmatched, partialMatchLen := t.search(str)
-	if unmatched == "" {
-		if partialMatchLen != 0 {
-			guaranteedContinuation = match.prefix[partialMatchLen:]
-			isExactMatch = len(match.children) == 0
-		} else {
-			if len(match.children) == 1 {
-				ch := match.getChild()
-				guaranteedContinuation = match.prefix + ch.prefix
-				isExactMatch = len(ch.children) == 0
-			} else {
-				isExactMatch = len(match.children) == 0
-			}
+func foldRune(r rune) rune {
+	return unicode.ToLower(unicode.ToUpper(r))
+}
+
+// Every rune is folded separately, so the key has as many runes as str
+func (t *Trie) key(str string) []rune {
+	key := []rune(str)
+	if t.foldCase {
+		for i, r := range key {
+			key[i] = foldRune(r)
 		}
+	}
+	return key
+}
 
+func commonPrefixLen(a, b []rune) int {
+	i := 0
+	for i < min(len(a), len(b)) && a[i] == b[i] {
+		i++
 	}
-	return
+	return i
+}
+
+// Continuation of str, common to all of the words starting with it.
+// isExactMatch if it leads to the single word. Continuation is folded
+// in the case insensitive trie.
+func (t *Trie) Search(str string) (guaranteedContinuation string, isExactMatch bool) {
+	node, rest := t.locate(t.key(str))
+	if node == nil {
+		return
+	}
+	continuation := append([]rune{}, rest...)
+	for !node.isCompleteWord && len(node.children) == 1 {
+		node = node.getChild()
+		continuation = append(continuation, node.prefix...)
+	}
+	return string(continuation), node.isCompleteWord && len(node.children) == 0
 }
 
-func (t *Trie) search(str string) (match *Node, unmatched string, partialMatchLen int) {
+// Node under which all the words starting with key are, and the part of
+// its prefix past the key. nil if there are no such words.
+func (t *Trie) locate(key []rune) (node *Node, rest []rune) {
+	node = t.root
+	for len(key) != 0 {
+		child, found := node.children[key[0]]
+		if !found {
+			return nil, nil
+		}
+		common := commonPrefixLen(child.prefix, key)
+		if common == len(key) {
+			return child, child.prefix[common:]
+		}
+		if common < len(child.prefix) {
+			return nil, nil
+		}
+		node = child
+		key = key[common:]
+	}
+	return node, nil
+}
+
+func (t *Trie) search(key []rune) (match *Node, unmatched []rune, partialMatchLen int) {
 	var node *Node
 	match = t.root
-	unmatched = str
-	unmatchedLen := len(unmatched)
+	unmatched = key
 	var found bool
-	for unmatchedLen != 0 {
+	for len(unmatched) != 0 {
 		node, found = match.children[unmatched[0]]
 		if !found {
 			// Full match with leftovers, leftovers irredusible
 			return
 		}
 		match = node
-		prefixLen := len(match.prefix)
-		if unmatchedLen >= prefixLen && match.prefix[1:] == unmatched[1:prefixLen] {
+		common := commonPrefixLen(match.prefix, unmatched)
+		if common == len(match.prefix) {
 			// Full match with leftovers, continue loop
-			unmatched = unmatched[prefixLen:]
-			unmatchedLen -= prefixLen
+			unmatched = unmatched[common:]
 		} else {
 			// Partial match, end
-			i := 1
-			for i < min(prefixLen, unmatchedLen) && match.prefix[i] == unmatched[i] {
-				i++
-			}
-			unmatched = unmatched[i:]
-			partialMatchLen = i
+			unmatched = unmatched[common:]
+			partialMatchLen = common
 			return
 		}
 	}
 	return
 }
 
-func New() *Trie {
-	return &Trie{
+func New(opts ...Option) *Trie {
+	t := &Trie{
 		root: &Node{
-			children: make(map[byte]*Node),
+			children: make(map[rune]*Node),
 		},
 		length: 0,
 	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
 }
 
 func (t *Trie) Add(str string) {
-	match, unmatched, partialLen := t.search(str)
+	t.Set(str, nil)
+}
+
+// Adds the word with the payload, replaces the payload if the word
+// is already there
+func (t *Trie) Set(str string, value interface{}) {
+	match, unmatched, partialLen := t.search(t.key(str))
 	if partialLen != 0 {
 		// Need to split the node
 		// Moving "match" to lower level
@@ -106,84 +179,130 @@ func (t *Trie) Add(str string) {
 			prefix:         match.prefix[partialLen:],
 			children:       match.children,
 			isCompleteWord: match.isCompleteWord,
+			word:           match.word,
+			value:          match.value,
 		}
 		for _, child := range match.children {
 			child.parent = newMatchNode
 		}
-		match.children = map[byte]*Node{
+		match.children = map[rune]*Node{
 			newMatchNode.prefix[0]: newMatchNode,
 		}
 
 		// Reconfiguring the other params
-		match.prefix = match.prefix[:partialLen]
+		match.prefix = match.prefix[:partialLen:partialLen]
 		match.isCompleteWord = false
-		// unmatched = unmatched[partialLen:]
+		match.word = ""
+		match.value = nil
 	}
-	if unmatched == "" {
+	if len(unmatched) == 0 {
 		if !match.isCompleteWord {
 			t.length++
 			match.isCompleteWord = true
 		}
 	} else {
 		t.length++
-		match.children[unmatched[0]] = &Node{
+		child := &Node{
 			parent:         match,
 			prefix:         unmatched,
 			isCompleteWord: true,
-			children:       make(map[byte]*Node),
+			children:       make(map[rune]*Node),
 		}
+		match.children[unmatched[0]] = child
+		match = child
 	}
+	match.word = str
+	match.value = value
 }
 
-func (t *Trie) Remove(str string) (isFound bool) {
-	defer func() {
-		if isFound {
-			t.length--
-		}
-	}()
+// Exactly matching word
+func (t *Trie) find(str string) *Node {
+	match, unmatched, partialLen := t.search(t.key(str))
+	if !match.isCompleteWord || len(unmatched) != 0 || partialLen != 0 {
+		return nil
+	}
+	return match
+}
 
-	match, unmatched, partialLen := t.search(str)
-	if unmatched != "" || partialLen != 0 {
-		return false
+// Payload of the word
+func (t *Trie) Get(str string) (value interface{}, found bool) {
+	match := t.find(str)
+	if match == nil {
+		return nil, false
+	}
+	return match.value, true
+}
+
+// Merges the node that is not a word with its only child
+func (n *Node) compress() {
+	if n.parent == nil || n.isCompleteWord || len(n.children) != 1 {
+		return
 	}
-	if !match.isCompleteWord {
+	child := n.getChild()
+	child.parent = n.parent
+	child.prefix = append(append([]rune{}, n.prefix...), child.prefix...)
+	n.parent.children[child.prefix[0]] = child
+}
+
+func (t *Trie) Remove(str string) (isFound bool) {
+	match := t.find(str)
+	if match == nil {
 		return false
 	}
+	t.length--
+	match.isCompleteWord = false
+	match.word = ""
+	match.value = nil
 
 	if match.parent == nil {
 		// We're root, don't move anything
-		match.isCompleteWord = false
 		return true
 	}
 	switch len(match.children) {
 	case 0:
 		// We have no children - delete us from parent
-		match.isCompleteWord = false
-		delete(match.parent.children, match.prefix[0])
-
+		parent := match.parent
+		delete(parent.children, match.prefix[0])
+		parent.compress()
 	case 1:
 		// We have one child, replce self with the child
-		var child, parent *Node
-		parent = match.parent
+		match.compress()
+	}
+	return true
+}
 
-		// Empty loop intended, allows to get child without knowing its prefix
-		for _, child = range match.children {
+func (t *Trie) Contains(str string) bool {
+	return t.find(str) != nil
+}
+
+func (t *Trie) Len() int {
+	return t.length
+}
+
+func (n *Node) complete(res []Entry, limit int) []Entry {
+	if n.isCompleteWord {
+		if limit > 0 && len(res) == limit {
+			return res
+		}
+		res = append(res, Entry{Word: n.word, Value: n.value})
+	}
+	for _, child := range n.sortedChildren() {
+		if limit > 0 && len(res) == limit {
 			break
 		}
-		child.parent = parent
-		child.prefix = match.prefix + child.prefix
-		parent.children[child.prefix[0]] = child
-
-	default:
-		// We have many children, just remove isCompleteWord
-		match.isCompleteWord = false
+		res = child.complete(res, limit)
 	}
-	return true
+	return res
 }
 
-func (t *Trie) Contains(str string) bool {
-	match, unmatched, partialLen := t.search(str)
-	return match.isCompleteWord && unmatched == "" && partialLen == 0
+// Words starting with prefix, sorted by their (folded) keys.
+// At most limit of them, if it's positive.
+func (t *Trie) Complete(prefix string, limit int) []Entry {
+	node, _ := t.locate(t.key(prefix))
+	if node == nil {
+		return nil
+	}
+	return node.complete(nil, limit)
 }
 
 func (n *Node) printTree(level int) {
@@ -191,29 +310,29 @@ func (n *Node) printTree(level int) {
 	if n.isCompleteWord {
 		fmt.Print("\x1b[0;32m")
 	}
-	fmt.Print(n.prefix)
+	fmt.Print(string(n.prefix))
 	prefLen := len(n.prefix)
 	if n.isCompleteWord {
 		fmt.Print("\x1b[0m")
 	}
 	fmt.Print("\n")
-	for _, ch := range n.children {
+	for _, ch := range n.sortedChildren() {
 		ch.printTree(level + prefLen)
 	}
 }
 func (t *Trie) Print() {
 	t.root.printTree(0)
 }
-func (n *Node) dumpData(prefix string, res *[]string) {
+func (n *Node) dumpData(res *[]string) {
 	if n.isCompleteWord {
-		*res = append(*res, prefix)
+		*res = append(*res, n.word)
 	}
 	for _, ch := range n.children {
-		ch.dumpData(prefix+ch.prefix, res)
+		ch.dumpData(res)
 	}
 }
 func (t *Trie) DumpData() *[]string {
 	res := make([]string, 0, t.length)
-	t.root.dumpData("", &res)
+	t.root.dumpData(&res)
 	return &res
 }
@@ -3,6 +3,7 @@ package trie
 import (
 	"fmt"
 	"math/rand"
+	"reflect"
 	"sort"
 	"testing"
 )
@@ -89,3 +90,80 @@ func TestTrie(t *testing.T) {
 	}
 	// trie.Print()
 }
+
+func entryWords(entries []Entry) []string {
+	words := make([]string, len(entries))
+	for n, entry := range entries {
+		words[n] = entry.Word
+	}
+	return words
+}
+
+func TestTrieUnicodeAndCase(t *testing.T) {
+	trie := New(FoldCase)
+	for n, word := range []string{"Задача1", "задание", "Zadacha", "ab", "abc", "abd"} {
+		trie.Set(word, n)
+	}
+
+	for _, tc := range []struct {
+		query        string
+		continuation string
+		exact        bool
+	}{
+		{"зад", "а", false},
+		{"ЗАДАЧ", "а1", true},
+		{"z", "adacha", true},
+		{"a", "b", false},
+		// "ab" is a word itself, nothing is guaranteed
+		{"ab", "", false},
+		{"abc", "", true},
+		{"x", "", false},
+	} {
+		continuation, exact := trie.Search(tc.query)
+		if continuation != tc.continuation || exact != tc.exact {
+			t.Errorf("Search(%q) = %q, %v; expected %q, %v", tc.query, continuation, exact, tc.continuation, tc.exact)
+		}
+	}
+
+	if value, found := trie.Get("ЗАДАНИЕ"); !found || value != 1 {
+		t.Errorf("Get = %v, %v", value, found)
+	}
+	if trie.Contains("задан") {
+		t.Error("prefix is not a word")
+	}
+	if New().Contains("ZADACHA") {
+		t.Error("case sensitive trie is empty")
+	}
+	if words := entryWords(trie.Complete("зАд", 0)); !reflect.DeepEqual(words, []string{"задание", "Задача1"}) {
+		t.Errorf("Complete = %v", words)
+	}
+}
+
+func TestTrieComplete(t *testing.T) {
+	trie := New()
+	for _, word := range []string{"rsa2", "rsa10", "rsa", "pwn", "rsb"} {
+		trie.Add(word)
+	}
+	if words := entryWords(trie.Complete("rs", 0)); !reflect.DeepEqual(words, []string{"rsa", "rsa10", "rsa2", "rsb"}) {
+		t.Errorf("Complete = %v", words)
+	}
+	if words := entryWords(trie.Complete("rs", 2)); !reflect.DeepEqual(words, []string{"rsa", "rsa10"}) {
+		t.Errorf("bounded Complete = %v", words)
+	}
+	if words := trie.Complete("x", 0); len(words) != 0 {
+		t.Errorf("Complete = %v", words)
+	}
+	if len(trie.Complete("", 0)) != 5 {
+		t.Error("empty prefix completes to everything")
+	}
+
+	// removal keeps the rest reachable
+	trie.Remove("rsa")
+	trie.Remove("rsb")
+	if continuation, exact := trie.Search("r"); continuation != "sa" || exact {
+		t.Errorf("Search after Remove = %q, %v", continuation, exact)
+	}
+	if trie.Len() != 3 {
+		t.Errorf("Len = %d", trie.Len())
+	}
+}
@@ -0,0 +1,142 @@
+package orca
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/Andrew-Morozko/orca/config"
+	"github.com/docker/docker/api/types/container"
+	"github.com/pkg/errors"
+)
+
+type SecurityProfile = string
+
+const (
+	// Docker defaults
+	SecurityProfileDefault SecurityProfile = "default"
+	// All capabilities except for the allowlist are dropped, no-new-privileges is set
+	SecurityProfileHardened SecurityProfile = "hardened"
+)
+
+// Capabilities kept by the hardened profile, enough for the usual shell stuff
+var hardenedCapabilities = []string{
+	"CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "SETGID", "SETUID",
+}
+
+func parseSecurity(lp *labelParser, cc *container.Config, hc *container.HostConfig) {
+	// global settings, images could override them
+	defaults := config.Get().Security
+	// but the hardened one could only be tightened
+	enforced := defaults.Profile == SecurityProfileHardened
+	profile := lp.String("orca.security.profile", defaults.Profile)
+	noNewPrivileges := false
+	switch profile {
+	case SecurityProfileDefault:
+		if enforced {
+			lp.Errorf("orca.security.profile: the server requires the hardened profile")
+		}
+	case SecurityProfileHardened:
+		noNewPrivileges = true
+		hc.CapDrop = []string{"ALL"}
+		hc.CapAdd = hardenedCapabilities
+	default:
+		lp.Errorf(`orca.security.profile: unknown security profile "%s"`, profile)
+	}
+
+	if val, found := lp.Lookup("orca.security.capabilities"); found {
+		if profile != SecurityProfileHardened {
+			lp.Errorf("orca.security.capabilities requires the hardened profile")
+		}
+		hc.CapAdd = nil
+		for _, capability := range strings.Split(val, ",") {
+			capability = strings.ToUpper(strings.TrimSpace(capability))
+			capability = strings.TrimPrefix(capability, "CAP_")
+			if capability == "" {
+				continue
+			}
+			if enforced && !isHardenedCapability(capability) {
+				lp.Errorf("orca.security.capabilities: %s is not allowed by the server", capability)
+			}
+			hc.CapAdd = append(hc.CapAdd, capability)
+		}
+	}
+
+	if lp.Bool("orca.security.nonewprivileges", noNewPrivileges) {
+		hc.SecurityOpt = append(hc.SecurityOpt, "no-new-privileges")
+	} else if enforced {
+		lp.Errorf("orca.security.nonewprivileges: required by the server")
+	}
+
+	if val, found := lp.Lookup("orca.security.seccomp"); found {
+		if enforced && val == "unconfined" {
+			lp.Errorf("orca.security.seccomp: the server doesn't allow unconfined containers")
+		}
+		opt, err := seccompOpt(val)
+		if err != nil {
+			lp.Errorf("orca.security.seccomp: %s", err)
+		} else {
+			hc.SecurityOpt = append(hc.SecurityOpt, opt)
+		}
+	}
+
+	if val, found := lp.Lookup("orca.security.apparmor"); found {
+		if enforced && val == "unconfined" {
+			lp.Errorf("orca.security.apparmor: the server doesn't allow unconfined containers")
+		}
+		hc.SecurityOpt = append(hc.SecurityOpt, "apparmor="+val)
+	}
+
+	hc.Runtime = lp.String("orca.security.runtime", defaults.Runtime)
+	if enforced && defaults.Runtime != "" && hc.Runtime != defaults.Runtime {
+		lp.Errorf("orca.security.runtime: the server requires the %s runtime", defaults.Runtime)
+	}
+
+	// user namespace remapping is configured in the docker daemon,
+	// containers could only opt out of it
+	userns := lp.String("orca.security.userns", "default")
+	switch userns {
+	case "default":
+	case "host":
+		if profile == SecurityProfileHardened {
+			lp.Errorf("orca.security.userns: hardened containers can't use the host user namespace")
+		}
+		hc.UsernsMode = "host"
+	default:
+		lp.Errorf(`orca.security.userns: unknown user namespace mode "%s"`, userns)
+	}
+
+	// user inside of the container, "uid[:gid]" or name
+	if val, found := lp.Lookup("orca.security.user"); found {
+		cc.User = val
+	}
+}
+
+func isHardenedCapability(capability string) bool {
+	for _, allowed := range hardenedCapabilities {
+		if capability == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Docker client passes the profile itself, not the path
+func seccompOpt(name string) (string, error) {
+	if name == "unconfined" {
+		return "seccomp=unconfined", nil
+	}
+	// only profiles from the configured directory
+	if filepath.Base(name) != name {
+		return "", errors.Errorf("seccomp profile \"%s\" must be a file name", name)
+	}
+	profile, err := ioutil.ReadFile(filepath.Join(config.Get().Security.SeccompDir, name))
+	if err != nil {
+		return "", errors.WithMessage(err, "reading seccomp profile")
+	}
+	if !json.Valid(profile) {
+		return "", errors.Errorf("seccomp profile \"%s\" is not valid json", name)
+	}
+	return "seccomp=" + string(profile), nil
+}
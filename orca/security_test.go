@@ -0,0 +1,104 @@
+package orca
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Andrew-Morozko/orca/config"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestParseSecurity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seccomp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "strict.json"), []byte(`{"defaultAction": "SCMP_ACT_ERRNO"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Set(config.Get())
+	conf := config.Default()
+	conf.Security.SeccompDir = dir
+	conf.Security.Runtime = "runsc"
+	config.Set(conf)
+
+	var cc container.Config
+	var hc container.HostConfig
+	lp := newLabelParser(labeledImage(map[string]string{
+		"orca.security.profile":      "hardened",
+		"orca.security.capabilities": "cap_setuid, setgid",
+		"orca.security.seccomp":      "strict.json",
+		"orca.security.apparmor":     "orca-default",
+		"orca.security.user":         "1000:1000",
+	}))
+	parseSecurity(lp, &cc, &hc)
+	if err := lp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(hc.CapDrop) != 1 || hc.CapDrop[0] != "ALL" {
+		t.Errorf("capdrop = %v", hc.CapDrop)
+	}
+	if len(hc.CapAdd) != 2 || hc.CapAdd[0] != "SETUID" || hc.CapAdd[1] != "SETGID" {
+		t.Errorf("capadd = %v", hc.CapAdd)
+	}
+	expectedOpts := []string{
+		"no-new-privileges",
+		`seccomp={"defaultAction": "SCMP_ACT_ERRNO"}`,
+		"apparmor=orca-default",
+	}
+	if len(hc.SecurityOpt) != len(expectedOpts) {
+		t.Fatalf("securityopt = %v", hc.SecurityOpt)
+	}
+	for n, opt := range expectedOpts {
+		if hc.SecurityOpt[n] != opt {
+			t.Errorf("securityopt[%d] = %s, expected %s", n, hc.SecurityOpt[n], opt)
+		}
+	}
+	if hc.Runtime != "runsc" || cc.User != "1000:1000" {
+		t.Errorf("runtime = %s, user = %s", hc.Runtime, cc.User)
+	}
+
+	for _, labels := range []map[string]string{
+		{"orca.security.profile": "paranoid"},
+		{"orca.security.capabilities": "chown"},
+		{"orca.security.seccomp": "../strict.json"},
+		{"orca.security.seccomp": "missing.json"},
+		{"orca.security.profile": "hardened", "orca.security.userns": "host"},
+	} {
+		lp := newLabelParser(labeledImage(labels))
+		parseSecurity(lp, &container.Config{}, &container.HostConfig{})
+		if lp.Err() == nil {
+			t.Errorf("%v: no error", labels)
+		}
+	}
+
+	// hardened server profile could be tightened, but not relaxed
+	conf.Security.Profile = "hardened"
+	config.Set(conf)
+	lp = newLabelParser(labeledImage(map[string]string{
+		"orca.security.capabilities": "kill",
+		"orca.security.seccomp":      "strict.json",
+	}))
+	parseSecurity(lp, &container.Config{}, &container.HostConfig{})
+	if err := lp.Err(); err != nil {
+		t.Errorf("tightening failed: %s", err)
+	}
+	for _, labels := range []map[string]string{
+		{"orca.security.profile": "default"},
+		{"orca.security.capabilities": "sys_admin"},
+		{"orca.security.nonewprivileges": "false"},
+		{"orca.security.seccomp": "unconfined"},
+		{"orca.security.apparmor": "unconfined"},
+		{"orca.security.runtime": "runc"},
+	} {
+		lp := newLabelParser(labeledImage(labels))
+		parseSecurity(lp, &container.Config{}, &container.HostConfig{})
+		if lp.Err() == nil {
+			t.Errorf("%v relaxed the hardened profile", labels)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package orca
+
+import (
+	"net"
+	"time"
+
+	"github.com/Andrew-Morozko/orca/jobcontroller"
+	"github.com/Andrew-Morozko/orca/mylog"
+)
+
+// How users connecting to tcp images are identified
+type TCPIdentify = string
+
+const (
+	// by remote ip address, transparent for the client
+	TCPIdentifyIP TCPIdentify = "ip"
+	// client is asked for the web token before the connection is spliced
+	TCPIdentifyToken TCPIdentify = "token"
+)
+
+// Set by the server, handles accepted connections to tcp images.
+// Handler must call jc.Job.Done() when finished.
+var TCPConnHandler func(jc jobcontroller.JobController, oi *Image, conn net.Conn)
+
+func (oi *Image) serveTCP(jc jobcontroller.JobController) {
+	defer jc.Job.Done()
+	jc = jc.AddLoggerPrefix("TCP listener").AddLoggerField(mylog.FieldImage, oi.Name)
+	jc.Logger.Log("Listening on ", oi.listener.Addr())
+
+	go func() {
+		select {
+		case <-jc.ShutdownRequested():
+		case <-jc.Done():
+		case <-oi.removedC:
+		}
+		oi.listener.Close()
+	}()
+
+	var tempDelay time.Duration
+	for {
+		conn, err := oi.listener.Accept()
+		if err != nil {
+			if jc.IsShuttingDown() || oi.IsRemoved() {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// same backoff as in net/http
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				jc.Logger.Warn.Err(err, "accept error, retrying in ", tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			jc.Logger.Error.Err(err, "listener died")
+			return
+		}
+		tempDelay = 0
+		jc.Job.Add(1)
+		go TCPConnHandler(jc, oi, conn)
+	}
+}
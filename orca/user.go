@@ -5,12 +5,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Andrew-Morozko/orca/config"
 	"github.com/Andrew-Morozko/orca/jobcontroller"
+	"github.com/Andrew-Morozko/orca/mylog"
+	"github.com/Andrew-Morozko/orca/orca/metrics"
 
 	"github.com/pkg/errors"
 )
@@ -22,11 +24,26 @@ type User struct {
 	// publicKeysLoaded bool
 	// publicKeys       []string
 	taskToken string
+	// as reported by the auth backend
+	groups []string
 }
 
 func (ui *User) String() string {
 	return fmt.Sprintf(`User{ID="%s"}`, ui.ID)
 }
+
+func (ui *User) Groups() []string {
+	ui.lock.Lock()
+	defer ui.lock.Unlock()
+	return ui.groups
+}
+
+// Groups are replaced on every login
+func (ui *User) setGroups(groups []string) {
+	ui.lock.Lock()
+	ui.groups = groups
+	ui.lock.Unlock()
+}
 func (ui *User) newContainerUser(jc jobcontroller.JobController, image *Image) (cu *ContainerUser) {
 	cu = &ContainerUser{
 		user:               ui,
@@ -39,9 +56,16 @@ func (ui *User) newContainerUser(jc jobcontroller.JobController, image *Image) (
 		noMoreConnectionsNotification: make(chan struct{}),
 		activityNotification:          make(chan struct{}, 1),
 		connectionDroppedNotification: make(chan struct{}),
+		queue:                         newQueueState(),
+		queueLeftC:                    make(chan struct{}),
+		abandonedC:                    make(chan struct{}),
+		infoC:                         make(chan chan ContainerUserInfo),
+		kickC:                         make(chan chan bool),
 	}
 	// creation requested => start the process of creating ?
-	jc = jc.AddLoggerPrefix("ContainerUser")
+	jc = jc.AddLoggerPrefix("ContainerUser").
+		AddLoggerField(mylog.FieldImage, image.Name).
+		AddLoggerField(mylog.FieldUser, ui.ID)
 	jc.Logger.Debug.Log("Created new ContainerUser")
 	jc.Job.Add(1)
 	go cu.manageContainerUserState(jc)
@@ -99,27 +123,43 @@ func (ul *UserList) GetUserByWebToken(tasktoken string) (ui *User, err error) {
 		DisableCompression: true,
 	}
 	client := &http.Client{Transport: tr}
-	resp, err := client.PostForm(os.Getenv("ORCA_HTTP_TOKEN_CHECKER"), url.Values{
+	start := time.Now()
+	resp, err := client.PostForm(config.Get().HTTP.TokenChecker, url.Values{
 		"token": {tasktoken},
 	})
+	metrics.AuthDuration.WithLabelValues("token").Observe(metrics.Since(start))
 
 	if err != nil {
+		metrics.AuthFailures.WithLabelValues("token", "error").Inc()
 		return nil, errors.WithMessage(err, "http request failed")
 	}
 	switch resp.StatusCode {
 	case 200:
 	case 403:
+		metrics.AuthFailures.WithLabelValues("token", "denied").Inc()
 		return nil, errors.New("Unknown token")
 	default:
+		metrics.AuthFailures.WithLabelValues("token", "error").Inc()
 		return nil, errors.New("Server error")
 	}
 	// got result
 
-	nameB, err := ioutil.ReadAll(resp.Body)
+	// First line is the user name, optional second line - comma separated groups
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, errors.WithMessage(err, "http request failed")
 	}
-	name := strings.TrimSpace(string(nameB))
+	lines := strings.SplitN(string(body), "\n", 2)
+	name := strings.TrimSpace(lines[0])
+	var groups []string
+	if len(lines) == 2 {
+		for _, group := range strings.Split(lines[1], ",") {
+			group = strings.TrimSpace(group)
+			if group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
 
 	ul.lock.Lock()
 	defer ul.lock.Unlock()
@@ -132,6 +172,7 @@ func (ul *UserList) GetUserByWebToken(tasktoken string) (ui *User, err error) {
 		ui.lock.Lock()
 		defer ui.lock.Unlock()
 		ui.taskToken = tasktoken
+		ui.groups = groups
 		ul.usersByWebtoken[tasktoken] = ui
 		return ui, nil
 	}
@@ -139,6 +180,7 @@ func (ul *UserList) GetUserByWebToken(tasktoken string) (ui *User, err error) {
 	ui = &User{
 		ID:        name,
 		taskToken: tasktoken,
+		groups:    groups,
 	}
 	ul.users[name] = ui
 	ul.usersByWebtoken[tasktoken] = ui
@@ -148,7 +190,23 @@ func (ul *UserList) GetUserByWebToken(tasktoken string) (ui *User, err error) {
 // This is a mess... This func is called with authorized user id via ldap rpc
 // TODO: restructure auth process, decouple auth methods from handlers in main
 // e.g.: web auth also could be performed via login/password
-func (ul *UserList) GetUserFromSSH(uid string) (ui *User, err error) {
+func (ul *UserList) GetUserFromSSH(uid string, groups []string) (ui *User, err error) {
+	ul.lock.Lock()
+	defer ul.lock.Unlock()
+	ui, found := ul.users[uid]
+	if !found {
+		ui = &User{
+			ID: uid,
+		}
+		ul.users[uid] = ui
+	}
+	ui.setGroups(groups)
+	return ui, nil
+}
+
+// Users of tcp images are identified by their address
+func (ul *UserList) GetUserFromAddr(ip string) (ui *User, err error) {
+	uid := "ip:" + ip
 	ul.lock.Lock()
 	defer ul.lock.Unlock()
 	ui, found := ul.users[uid]
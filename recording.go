@@ -0,0 +1,78 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/Andrew-Morozko/orca/config"
+	"github.com/Andrew-Morozko/orca/jobcontroller"
+	"github.com/Andrew-Morozko/orca/orca"
+	"github.com/Andrew-Morozko/orca/orca/asciicast"
+	"github.com/Andrew-Morozko/orca/orca/mydocker"
+	orcassh "github.com/Andrew-Morozko/orca/orca/ssh"
+)
+
+const recordingsCleanupInterval = time.Hour
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+func safeFileName(name string) string {
+	return unsafeFileChars.ReplaceAllString(name, "_")
+}
+
+// Starts the recording of the session if the image asks for it.
+// nil if it doesn't, or if the recording can't be created (session goes on).
+func startRecording(jc jobcontroller.JobController, sess *orcassh.SSHSession, oi *orca.Image, ui *orca.User, oc *orca.Container) *asciicast.Recorder {
+	if !oi.Record {
+		return nil
+	}
+	width, height, term := 80, 24, ""
+	if pty, _, isPty := sess.Pty(); isPty {
+		width, height, term = pty.Window.Width, pty.Window.Height, pty.Term
+	}
+	// <dir>/<image>/<time>_<user>_<container>.cast
+	path := filepath.Join(
+		config.Get().Recordings.Dir,
+		safeFileName(oi.Name),
+		safeFileName(time.Now().UTC().Format("20060102T150405.000Z")+"_"+ui.ID+"_"+mydocker.ShortID(oc.DockerID))+".cast",
+	)
+	rec, err := asciicast.Create(path, width, height, term, asciicast.Metadata{
+		User:      ui.ID,
+		Image:     oi.Name,
+		Container: oc.DockerID,
+	})
+	if err != nil {
+		jc.Logger.Error.Err(err, "failed to start the recording")
+		return nil
+	}
+	jc.Logger.Log("Recording the session to ", path)
+	return rec
+}
+
+// Enforces the retention limits of the recordings
+func recordingsJanitor(jc jobcontroller.JobController, shutdownReq <-chan struct{}) {
+	jc = jc.AddLoggerPrefix("Recordings")
+	jc.Job.Add(1)
+	go func() {
+		defer jc.Job.Done()
+		ticker := time.NewTicker(recordingsCleanupInterval)
+		defer ticker.Stop()
+		for {
+			// limits could be changed by the reload
+			conf := config.Get().Recordings
+			removed, err := asciicast.Cleanup(conf.Dir, conf.MaxAge, int64(conf.MaxSizeMB)<<20)
+			jc.Logger.Warn.Err(err, "cleanup failed")
+			if removed != 0 {
+				jc.Logger.Logf("Removed %d old recordings", removed)
+			}
+			select {
+			case <-ticker.C:
+			case <-shutdownReq:
+				return
+			case <-jc.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Andrew-Morozko/orca/config"
+	"github.com/Andrew-Morozko/orca/orca"
+	trie "github.com/Andrew-Morozko/orca/orca/search"
+	orcassh "github.com/Andrew-Morozko/orca/orca/ssh"
+	"github.com/facette/natsort"
+	"github.com/gliderlabs/ssh"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var userFail = errors.New("User failed to select the task")
+
+// Task named in the login ("ssh user+task@orca") or by the command
+// ("ssh user@orca task"), the menu if there is none or it's not found.
+// Sessions without a pty can't use the menu, they get the list of the tasks.
+// unread is the input typed after the selection, it's for the container.
+func selectSSHImage(rw io.ReadWriter, sess *orcassh.SSHSession, ui *orca.User) (oi *orca.Image, unread []byte, err error) {
+	msgOut := io.Writer(rw)
+	newline := "\r\n"
+	if !sess.IsPty() {
+		msgOut = sess.Stderr()
+		newline = "\n"
+	}
+
+	task, _ := sess.Context().Value("Task").(string)
+	if task == "" {
+		cmd := sess.Command()
+		if len(cmd) > 1 {
+			_, _ = fmt.Fprintf(msgOut, "Expected only the task name, got %q%s", cmd, newline)
+			return nil, nil, userFail
+		}
+		if len(cmd) == 1 {
+			task = cmd[0]
+		}
+	}
+	if task != "" {
+		oi, err = imageList.GetImage(orca.ImageKindSSH, strings.ToLower(task), ui)
+		switch err {
+		case nil:
+			return oi, nil, nil
+		case orca.ImageNotFoundErr, orca.ImageNotAvailibleErr:
+			// unavailible tasks are not found for the user
+			names := sortedTaskNames(imageList.GetImages(orca.ImageKindSSH, ui))
+			_, _ = fmt.Fprintf(msgOut, "Task %q not found%s%s", task, didYouMean(strings.ToLower(task), names), newline)
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if sess.IsPty() {
+		if config.Get().SSH.Menu == "tui" {
+			if pty, _, _ := sess.Pty(); tuiSupported(pty) {
+				return tuiPicker(rw, sess.SetPTYHandler, pty.Window, ui)
+			}
+		}
+		oi, err = sshMenu(rw, sess.SetPTYHandler, ui)
+		return oi, nil, err
+	}
+	tasks := imageList.GetImages(orca.ImageKindSSH, ui)
+	_, _ = fmt.Fprintf(msgOut, "Usage: ssh %s+<task>@<host> or ssh %s@<host> <task>\n\n%s",
+		ui.ID, ui.ID, formatTaskList(tasks, sortedTaskNames(tasks), ui))
+	return nil, nil, userFail
+}
+
+// Tab lists at most that many tasks
+const maxCompletions = 20
+
+// Tasks starting with prefix in natural order, "..." at the end if some didn't fit
+func completions(search *trie.Trie, prefix string) []string {
+	entries := search.Complete(prefix, maxCompletions+1)
+	candidates := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		candidates = append(candidates, entry.Word)
+	}
+	more := len(candidates) > maxCompletions
+	if more {
+		candidates = candidates[:maxCompletions]
+	}
+	natsort.Sort(candidates)
+	if more {
+		candidates = append(candidates, "...")
+	}
+	return candidates
+}
+
+func sshMenu(rw io.ReadWriter, phs orcassh.PTYHandlerSetter, ui *orca.User) (oi *orca.Image, err error) {
+	term := terminal.NewTerminal(rw, "Select the task: ")
+
+	// Update the terminal size
+	phs(func(win ssh.Window) {
+		_ = term.SetSize(win.Width, win.Height)
+	})
+
+	tasks := imageList.GetImages(orca.ImageKindSSH, ui)
+	names := sortedTaskNames(tasks)
+	_, err = io.WriteString(term, formatTaskList(tasks, names, ui))
+	if err != nil {
+		return
+	}
+
+	search := trie.New(trie.FoldCase)
+	for name, task := range tasks {
+		search.Set(name, task)
+	}
+
+	term.AutoCompleteCallback = func(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
+		if key != '\t' {
+			return line, pos, false
+		}
+		gc, em := search.Search(line)
+		if gc != "" {
+			newLine = line + gc
+			if em {
+				newLine += " "
+			}
+			return newLine, len(newLine), true
+		}
+		// nothing to add, but there may be several tasks to choose from
+		if candidates := completions(search, line); len(candidates) > 1 {
+			// the terminal isn't locked while the callback runs, the write
+			// goes before the next key and the line is redrawn after it
+			_, _ = io.WriteString(term, strings.Join(candidates, "  ")+"\n")
+		}
+		return line, pos, false
+	}
+
+	var selectedTask string
+	for attempt := 0; attempt < 3; attempt++ {
+		selectedTask, err = term.ReadLine()
+		if err != nil {
+			return
+		}
+		selectedTask = strings.TrimSpace(selectedTask)
+
+		if task, found := search.Get(selectedTask); found {
+			return task.(*orca.Image), nil
+		}
+		_, err = io.WriteString(term, "Not found!"+didYouMean(strings.ToLower(selectedTask), names)+"\n")
+		if err != nil {
+			return
+		}
+	}
+	_, err = io.WriteString(term, "Failed to select the task\n")
+	if err != nil {
+		return
+	}
+	err = userFail
+	return
+}
+
+// " Did you mean ...?" if there are tasks with the similar names
+func didYouMean(task string, names []string) string {
+	if task == "" {
+		return ""
+	}
+	similar := trie.Similar(task, names, 3)
+	if len(similar) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" Did you mean %s?", strings.Join(similar, ", "))
+}
+
+// Tasks grouped by the category (uncategorized ones first) with their
+// descriptions. Tasks the user has a running container of are marked.
+func formatTaskList(tasks map[string]*orca.Image, names []string, ui *orca.User) string {
+	categories, byCategory := groupTasks(tasks, names)
+	width := maxRuneCount(names)
+
+	var sb strings.Builder
+	sb.WriteString("Availible tasks:\n\n")
+	anyRunning := false
+	writeTasks := func(names []string) {
+		for _, name := range names {
+			oi := tasks[name]
+			marker := " "
+			if oi.HasRunningContainer(ui) {
+				marker = "*"
+				anyRunning = true
+			}
+			line := fmt.Sprintf("  %s %-*s  %s", marker, width, name, strings.Join(strings.Fields(oi.Description), " "))
+			sb.WriteString(strings.TrimRight(line, " "))
+			sb.WriteString("\n")
+		}
+		sb.WriteString("\n")
+	}
+	if len(byCategory[""]) != 0 {
+		writeTasks(byCategory[""])
+	}
+	for _, category := range categories {
+		sb.WriteString(category + ":\n")
+		writeTasks(byCategory[category])
+	}
+	if anyRunning {
+		sb.WriteString("* - your container is still running, you'll get back to it\n\n")
+	}
+	return sb.String()
+}
+
+// Sorted categories of the tasks ("" isn't one) and sorted task names by the category
+func groupTasks(tasks map[string]*orca.Image, names []string) (categories []string, byCategory map[string][]string) {
+	byCategory = make(map[string][]string)
+	for _, name := range names {
+		category := tasks[name].Category
+		if _, found := byCategory[category]; !found && category != "" {
+			categories = append(categories, category)
+		}
+		byCategory[category] = append(byCategory[category], name)
+	}
+	natsort.Sort(categories)
+	return
+}
+
+func maxRuneCount(strs []string) (max int) {
+	for _, str := range strs {
+		if n := utf8.RuneCountInString(str); n > max {
+			max = n
+		}
+	}
+	return
+}
+
+func sortedTaskNames(tasks map[string]*orca.Image) []string {
+	tasknames := make([]string, 0, len(tasks))
+	for taskname := range tasks {
+		tasknames = append(tasknames, taskname)
+	}
+	natsort.Sort(tasknames)
+	return tasknames
+}
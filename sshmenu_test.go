@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	trie "github.com/Andrew-Morozko/orca/orca/search"
+)
+
+func TestCompletions(t *testing.T) {
+	search := trie.New(trie.FoldCase)
+	for i := 1; i <= 25; i++ {
+		search.Set(fmt.Sprintf("task%d", i), nil)
+	}
+	candidates := completions(search, "task")
+	if len(candidates) != maxCompletions+1 || candidates[maxCompletions] != "..." {
+		t.Fatalf("got %v", candidates)
+	}
+	// trimmed before sorting, so every listed name is real
+	seen := make(map[string]bool)
+	for _, name := range candidates[:maxCompletions] {
+		if _, found := search.Get(name); !found || seen[name] {
+			t.Errorf("%q is listed, but it's not a task or a duplicate", name)
+		}
+		seen[name] = true
+	}
+	if candidates[0] != "task1" || candidates[1] != "task2" || candidates[maxCompletions-1] != "task25" {
+		t.Errorf("not in natural order: %v", candidates)
+	}
+
+	if candidates := completions(search, "task2"); len(candidates) != 7 || candidates[6] != "task25" {
+		t.Errorf("got %v", candidates)
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Andrew-Morozko/orca/orca"
+	trie "github.com/Andrew-Morozko/orca/orca/search"
+	orcassh "github.com/Andrew-Morozko/orca/orca/ssh"
+	"github.com/docker/go-units"
+	"github.com/gliderlabs/ssh"
+)
+
+// Smaller terminals get the line prompt
+const (
+	tuiMinWidth  = 40
+	tuiMinHeight = 10
+	// detail pane is hidden if it would be narrower
+	tuiMinDetailWidth = 24
+)
+
+const tuiHelp = " Up/Down: select  Enter: start  Tab: complete  Esc: clear  Ctrl-C: quit"
+
+func tuiSupported(pty ssh.Pty) bool {
+	switch pty.Term {
+	case "", "dumb", "unknown":
+		return false
+	}
+	return pty.Window.Width >= tuiMinWidth && pty.Window.Height >= tuiMinHeight
+}
+
+// Full screen task picker: arrows move the selection, typing filters the
+// tasks by the prefix (or by similarity if there are none), the detail pane
+// shows the selected one.
+// Input is read only till the selection, the rest of the last read belongs
+// to the container and is returned as unread.
+func tuiPicker(rw io.ReadWriter, phs orcassh.PTYHandlerSetter, win ssh.Window, ui *orca.User) (oi *orca.Image, unread []byte, err error) {
+	tasks := imageList.GetImages(orca.ImageKindSSH, ui)
+	tm := &tuiMenu{
+		w:       rw,
+		tasks:   tasks,
+		search:  trie.New(trie.FoldCase),
+		running: make(map[string]bool),
+		width:   win.Width,
+		height:  win.Height,
+	}
+	categories, byCategory := groupTasks(tasks, sortedTaskNames(tasks))
+	// same order as in the line menu
+	tm.names = byCategory[""]
+	for _, category := range categories {
+		tm.names = append(tm.names, byCategory[category]...)
+	}
+	for _, name := range tm.names {
+		tm.search.Set(name, tasks[name])
+		tm.running[name] = tasks[name].HasRunningContainer(ui)
+	}
+	tm.nameWidth = maxRuneCount(tm.names)
+	tm.applyFilter()
+
+	// alternate screen, hidden cursor
+	_, err = io.WriteString(rw, "\x1b[?1049h\x1b[?25l")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		tm.lock.Lock()
+		tm.closed = true
+		_, _ = io.WriteString(rw, "\x1b[?25h\x1b[?1049l")
+		tm.lock.Unlock()
+	}()
+
+	phs(func(win ssh.Window) {
+		tm.lock.Lock()
+		defer tm.lock.Unlock()
+		tm.width, tm.height = win.Width, win.Height
+		tm.render()
+	})
+	tm.lock.Lock()
+	tm.render()
+	tm.lock.Unlock()
+
+	buf := make([]byte, 256)
+	for {
+		n, err := rw.Read(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		tm.lock.Lock()
+		oi, unread, quit := tm.handleInput(buf[:n])
+		if oi == nil && !quit {
+			tm.render()
+		}
+		tm.lock.Unlock()
+		switch {
+		case quit:
+			return nil, nil, userFail
+		case oi != nil:
+			return oi, unread, nil
+		}
+	}
+}
+
+type tuiMenu struct {
+	// input and resizes come from the different goroutines
+	lock   sync.Mutex
+	w      io.Writer
+	closed bool
+
+	tasks     map[string]*orca.Image
+	names     []string
+	search    *trie.Trie
+	running   map[string]bool
+	nameWidth int
+
+	filter string
+	// unfinished escape sequence or rune from the end of the last read
+	pending []byte
+	// tasks matching the filter
+	visible []string
+	// fuzzy matches are shown, nothing has the prefix
+	similar  bool
+	selected int
+	// first task on the screen
+	offset int
+
+	width, height int
+}
+
+func (tm *tuiMenu) applyFilter() {
+	var current string
+	if tm.selected < len(tm.visible) {
+		current = tm.visible[tm.selected]
+	}
+	matching := make(map[string]bool)
+	for _, entry := range tm.search.Complete(tm.filter, 0) {
+		matching[entry.Word] = true
+	}
+	tm.visible = tm.visible[:0]
+	for _, name := range tm.names {
+		if matching[name] {
+			tm.visible = append(tm.visible, name)
+		}
+	}
+	tm.similar = false
+	if len(tm.visible) == 0 && tm.filter != "" {
+		tm.visible = trie.Similar(strings.ToLower(tm.filter), tm.names, len(tm.names))
+		tm.similar = len(tm.visible) != 0
+	}
+	// selection stays on the same task if it's still there
+	tm.selected = 0
+	for n, name := range tm.visible {
+		if name == current {
+			tm.selected = n
+		}
+	}
+}
+
+func (tm *tuiMenu) move(delta int) {
+	tm.selected += delta
+	if tm.selected >= len(tm.visible) {
+		tm.selected = len(tm.visible) - 1
+	}
+	if tm.selected < 0 {
+		tm.selected = 0
+	}
+}
+
+func (tm *tuiMenu) listRows() int {
+	// title, filter, empty line and help
+	return tm.height - 4
+}
+
+// Selected image with the input after the selection, or quit if the user gave up
+func (tm *tuiMenu) handleInput(data []byte) (oi *orca.Image, unread []byte, quit bool) {
+	if tm.pending != nil {
+		data = append(tm.pending, data...)
+		tm.pending = nil
+	}
+	// escape key on its own, not the start of a sequence
+	loneEscape := len(data) == 1
+	for len(data) != 0 {
+		switch data[0] {
+		case 0x03, 0x04: // ctrl-c, ctrl-d
+			return nil, nil, true
+		case '\r', '\n':
+			if len(tm.visible) != 0 {
+				return tm.tasks[tm.visible[tm.selected]], data[1:], false
+			}
+		case 0x7f, 0x08: // backspace
+			if tm.filter != "" {
+				_, size := utf8.DecodeLastRuneInString(tm.filter)
+				tm.filter = tm.filter[:len(tm.filter)-size]
+				tm.applyFilter()
+			}
+		case 0x15: // ctrl-u
+			tm.filter = ""
+			tm.applyFilter()
+		case 0x10: // ctrl-p
+			tm.move(-1)
+		case 0x0e: // ctrl-n
+			tm.move(1)
+		case '\t':
+			if gc, _ := tm.search.Search(tm.filter); gc != "" {
+				tm.filter += gc
+				tm.applyFilter()
+			}
+		case 0x1b:
+			seq, rest, complete := escapeSequence(data)
+			if !complete && !loneEscape {
+				// the rest comes with the next read
+				tm.pending = append([]byte(nil), data...)
+				return nil, nil, false
+			}
+			data = rest
+			switch seq {
+			case "":
+				// lone escape
+				tm.filter = ""
+				tm.applyFilter()
+			case "[A", "OA":
+				tm.move(-1)
+			case "[B", "OB":
+				tm.move(1)
+			case "[5~":
+				tm.move(-tm.listRows())
+			case "[6~":
+				tm.move(tm.listRows())
+			case "[H", "OH", "[1~":
+				tm.move(-len(tm.visible))
+			case "[F", "OF", "[4~":
+				tm.move(len(tm.visible))
+			}
+			continue
+		default:
+			if !utf8.FullRune(data) {
+				tm.pending = append([]byte(nil), data...)
+				return nil, nil, false
+			}
+			r, size := utf8.DecodeRune(data)
+			if unicode.IsPrint(r) {
+				tm.filter += string(r)
+				tm.applyFilter()
+			}
+			data = data[size:]
+			continue
+		}
+		data = data[1:]
+	}
+	return nil, nil, false
+}
+
+// Splits off the escape sequence (without the ESC) at the start of data.
+// Not complete if data ends before the sequence does.
+func escapeSequence(data []byte) (seq string, rest []byte, complete bool) {
+	data = data[1:]
+	if len(data) == 0 {
+		return "", data, false
+	}
+	if data[0] != '[' && data[0] != 'O' {
+		return "", data, true
+	}
+	if len(data) < 2 {
+		return "", data, false
+	}
+	if data[0] == 'O' {
+		return string(data[:2]), data[2:], true
+	}
+	// CSI: parameters, then the final byte
+	for i := 1; i < len(data); i++ {
+		if data[i] >= 0x40 && data[i] <= 0x7e {
+			return string(data[:i+1]), data[i+1:], true
+		}
+	}
+	return "", data, false
+}
+
+// Truncates or pads s to exactly width runes
+func fitWidth(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	n := utf8.RuneCountInString(s)
+	if n > width {
+		return string([]rune(s)[:width])
+	}
+	return s + strings.Repeat(" ", width-n)
+}
+
+// Splits the text into the lines of at most width runes
+func wrapText(text string, width int) (lines []string) {
+	line := ""
+	for _, word := range strings.Fields(text) {
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+		for utf8.RuneCountInString(line) > width {
+			lines = append(lines, string([]rune(line)[:width]))
+			line = string([]rune(line)[width:])
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return
+}
+
+// Detail pane of the task, first line is the title
+func (tm *tuiMenu) details(name string, width int) []string {
+	oi := tm.tasks[name]
+	lines := []string{name}
+	if oi.Category != "" {
+		lines = append(lines, "Category: "+oi.Category)
+	}
+	if oi.Description != "" {
+		lines = append(lines, "")
+		lines = append(lines, wrapText(oi.Description, width)...)
+	}
+	lines = append(lines, "")
+	lines = append(lines, fmt.Sprintf("Session limit: %s", oi.Timeouts.Total))
+	lines = append(lines, fmt.Sprintf("Inactivity limit: %s", oi.Timeouts.Inactive))
+	limits := oi.ResourceLimits()
+	if limits.Memory != 0 {
+		lines = append(lines, "Memory: "+units.BytesSize(float64(limits.Memory)))
+	}
+	if limits.CPUs != 0 {
+		lines = append(lines, fmt.Sprintf("CPUs: %g", limits.CPUs))
+	}
+	if limits.Pids != 0 {
+		lines = append(lines, fmt.Sprintf("Processes: %d", limits.Pids))
+	}
+	if tm.running[name] {
+		lines = append(lines, "", "Your container is running,", "you'll get back to it")
+	}
+	return lines
+}
+
+// Redraws the whole screen, must hold the lock
+func (tm *tuiMenu) render() {
+	if tm.closed {
+		return
+	}
+	width, rows := tm.width, tm.listRows()
+	listWidth := tm.nameWidth + 4
+	detailWidth := width - listWidth - 3
+	if detailWidth < tuiMinDetailWidth {
+		listWidth, detailWidth = width, 0
+	}
+
+	// keep the selection on the screen
+	if tm.selected < tm.offset {
+		tm.offset = tm.selected
+	}
+	if rows > 0 && tm.selected >= tm.offset+rows {
+		tm.offset = tm.selected - rows + 1
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\x1b[H\x1b[2J")
+	sb.WriteString("\x1b[7m" + fitWidth(" Select the task", width) + "\x1b[0m\r\n")
+	filterLine := " Filter: " + tm.filter
+	if tm.similar {
+		filterLine += "  (no matches, similar tasks are shown)"
+	}
+	sb.WriteString(fitWidth(filterLine, width) + "\r\n\r\n")
+
+	var details []string
+	if detailWidth != 0 && len(tm.visible) != 0 {
+		details = tm.details(tm.visible[tm.selected], detailWidth)
+	}
+	for row := 0; row < rows; row++ {
+		n := tm.offset + row
+		switch {
+		case n < len(tm.visible):
+			marker := " "
+			if tm.running[tm.visible[n]] {
+				marker = "*"
+			}
+			item := fitWidth(" "+marker+" "+tm.visible[n], listWidth)
+			if n == tm.selected {
+				item = "\x1b[7m" + item + "\x1b[0m"
+			}
+			sb.WriteString(item)
+		case row == 0:
+			sb.WriteString(fitWidth("   no tasks found", listWidth))
+		default:
+			sb.WriteString(fitWidth("", listWidth))
+		}
+		if detailWidth != 0 {
+			sb.WriteString(" | ")
+			if row < len(details) {
+				line := fitWidth(details[row], detailWidth)
+				if row == 0 {
+					line = "\x1b[1m" + line + "\x1b[0m"
+				}
+				sb.WriteString(line)
+			}
+		}
+		sb.WriteString("\r\n")
+	}
+	// no newline, the screen would scroll
+	sb.WriteString(fitWidth(tuiHelp, width))
+	_, _ = io.WriteString(tm.w, sb.String())
+}
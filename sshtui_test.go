@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Andrew-Morozko/orca/orca"
+	trie "github.com/Andrew-Morozko/orca/orca/search"
+)
+
+func TestTUISplitInput(t *testing.T) {
+	tm := &tuiMenu{
+		tasks:  map[string]*orca.Image{"alpha": {Name: "alpha"}, "beta": {Name: "beta"}},
+		names:  []string{"alpha", "beta"},
+		search: trie.New(trie.FoldCase),
+		height: 10,
+	}
+	for _, name := range tm.names {
+		tm.search.Set(name, tm.tasks[name])
+	}
+	tm.applyFilter()
+
+	// arrow down, cut in the middle
+	tm.handleInput([]byte("\x1b["))
+	if tm.selected != 0 || tm.filter != "" {
+		t.Fatalf("half of the sequence was handled: selected %d, filter %q", tm.selected, tm.filter)
+	}
+	tm.handleInput([]byte("B"))
+	if tm.selected != 1 {
+		t.Errorf("selected %d after arrow down", tm.selected)
+	}
+
+	// "é" in two reads
+	tm.handleInput([]byte{0xc3})
+	tm.handleInput([]byte{0xa9})
+	if tm.filter != "é" {
+		t.Errorf("filter is %q", tm.filter)
+	}
+
+	// escape key clears the filter right away
+	tm.handleInput([]byte{0x1b})
+	if tm.filter != "" {
+		t.Errorf("filter is %q after escape", tm.filter)
+	}
+
+	// pasted task and the command for it
+	oi, unread, quit := tm.handleInput([]byte("alp\rls\r"))
+	if oi != tm.tasks["alpha"] || quit || string(unread) != "ls\r" {
+		t.Errorf("got %v, unread %q, quit %v", oi, unread, quit)
+	}
+}